@@ -2,18 +2,26 @@ package log_v1
 
 import (
 	"fmt"
+	"strconv"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+//	ErrOffsetOutOfRange is returned when there's no record at Offset. Lowest
+//		and Highest are the log's bounds at the time, so a client can tell an
+//		offset that was already deleted (below Lowest) from one that hasn't
+//		been written yet (above Highest)
 type ErrOffsetOutOfRange struct {
-	Offset uint64
+	Offset  uint64
+	Lowest  uint64
+	Highest uint64
 }
 
 func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 	st := status.New(
-		404,
+		codes.OutOfRange,
 		fmt.Sprintf("offset out of range: %d", e.Offset),
 	)
 	msg := fmt.Sprintf(
@@ -24,7 +32,16 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 		Locale: "en-US",
 		Message: msg,
 	}
-	std, err := st.WithDetails(d)
+	info := &errdetails.ErrorInfo{
+		Reason: "OFFSET_OUT_OF_RANGE",
+		Domain: "hydralog",
+		Metadata: map[string]string{
+			"offset":  strconv.FormatUint(e.Offset, 10),
+			"lowest":  strconv.FormatUint(e.Lowest, 10),
+			"highest": strconv.FormatUint(e.Highest, 10),
+		},
+	}
+	std, err := st.WithDetails(d, info)
 	if err != nil {
 		return st
 	}
@@ -34,3 +51,98 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+type ErrTopicNotFound struct {
+	Topic string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	return status.New(
+		codes.NotFound,
+		fmt.Sprintf("topic not found: %s", e.Topic),
+	)
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrTopicExists struct {
+	Topic string
+}
+
+func (e ErrTopicExists) GRPCStatus() *status.Status {
+	return status.New(
+		codes.AlreadyExists,
+		fmt.Sprintf("topic already exists: %s", e.Topic),
+	)
+}
+
+func (e ErrTopicExists) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrInvalidTopic struct {
+	Topic string
+}
+
+func (e ErrInvalidTopic) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("invalid topic name: %q", e.Topic),
+	)
+	d := &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       "topic",
+			Description: "topic names are 1-249 letters, digits, '.', '_' or '-' and can't start with '.'",
+		}},
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrInvalidTopic) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrCorruptRecord struct {
+	Offset uint64
+}
+
+func (e ErrCorruptRecord) GRPCStatus() *status.Status {
+	return status.New(
+		codes.DataLoss,
+		fmt.Sprintf("record failed its checksum: %d", e.Offset),
+	)
+}
+
+func (e ErrCorruptRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+//	ErrNotLeader is returned for requests only the cluster's leader can serve.
+//		Leader is its address, when this node knows it
+type ErrNotLeader struct {
+	Leader string
+}
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, "this node isn't the leader")
+	info := &errdetails.ErrorInfo{
+		Reason:   "NOT_LEADER",
+		Domain:   "hydralog",
+		Metadata: map[string]string{"leader": e.Leader},
+	}
+	std, err := st.WithDetails(info)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
@@ -0,0 +1,72 @@
+//	hydralog-trim drops old segments from a log directory while the server is
+//		stopped, e.g. to shrink a copied dataset before restoring it somewhere
+//		smaller. It goes through the same Log.Truncate path the server uses
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/NathanClassen/hydralog/internal/log"
+)
+
+func main() {
+	dir := flag.String("dir", "", "data directory to trim")
+	topic := flag.String("topic", log.DefaultTopic, "topic whose log is trimmed")
+	lowest := flag.Uint64("lowest", 0, "drop every segment whose records are all at or below this offset")
+	force := flag.Bool("force", false, "trim even if the log wasn't shut down cleanly")
+	keyFile := flag.String("encryption-key-file", "", "the server's AES key file, for a log it encrypts")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "hydralog-trim: -dir is required")
+		os.Exit(2)
+	}
+	c := log.Config{}
+	if *keyFile != "" {
+		keys, err := log.ReadKeyFile(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hydralog-trim: %v\n", err)
+			os.Exit(2)
+		}
+		c.Store.Encryption.Keys = keys
+	}
+	if err := trim(path.Join(*dir, *topic), c, *lowest, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "hydralog-trim: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func trim(dir string, c log.Config, lowest uint64, force bool) error {
+	//	a log that's open (or crashed) doesn't have a clean manifest; trimming
+	//		underneath a running server would corrupt it
+	clean, err := log.CleanlyClosed(dir)
+	if err != nil {
+		return err
+	}
+	if !clean && !force {
+		return fmt.Errorf("%s was not shut down cleanly; stop the server or pass -force", dir)
+	}
+
+	if c, err = log.FitSegments(dir, c); err != nil {
+		return err
+	}
+	l, err := log.NewLog(dir, c)
+	if log.IsKeyError(err) {
+		return fmt.Errorf("%s is encrypted: %w; pass the server's -encryption-key-file", dir, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	before, _ := l.LowestOffset()
+	if err = l.Truncate(lowest); err != nil {
+		l.Close()
+		return err
+	}
+	after, _ := l.LowestOffset()
+	fmt.Printf("lowest offset %d -> %d\n", before, after)
+	return l.Close()
+}
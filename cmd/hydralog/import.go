@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
+)
+
+//	records are appended this many at a time, so each batch is one write to
+//		the store
+const importBatch = 1024
+
+//	runImport is the import subcommand. It bulk-loads files into a topic of a
+//		log directory that isn't running, appending to the segments directly
+//		rather than producing over RPC. Logs a cluster replicates are rebuilt
+//		from raft on startup, so this is for standalone and embedded logs.
+//		Records are stored the way the server stores them: pass its -config
+//		file, or the same store flags it runs with
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("hydralog import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hydralog import -dir DIR [-config FILE] [flags] [file ...]")
+		fmt.Fprintln(fs.Output(), "reads stdin when no files are given")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "", "topics directory to import into")
+	topic := fs.String("topic", log.DefaultTopic, "topic to append to; created if needed")
+	format := fs.String("format", "text", "text: a record per line; jsonl: a JSON value per line; binary: records each prefixed with an 8 byte big endian length")
+	keyField := fs.String("key-field", "", "jsonl: top-level string field to use as the record key")
+	timeField := fs.String("time-field", "", "jsonl: top-level field to take the timestamp from, as RFC 3339 or unix nanoseconds")
+	configFile := fs.String("config", "", "the server's YAML config file, to take its store settings from")
+	sc := &config{}
+	storeFlags(fs, sc)
+	if err := parseWithConfigFile(fs, args, configFile, sc); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	var parse func(r io.Reader, fn func(*api.Record) error) error
+	switch *format {
+	case "text":
+		parse = parseText
+	case "jsonl":
+		parse = func(r io.Reader, fn func(*api.Record) error) error {
+			return parseJSONL(r, *keyField, *timeField, fn)
+		}
+	case "binary":
+		parse = parseBinary
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	c, err := sc.logConfig()
+	if err != nil {
+		return err
+	}
+
+	if !log.ValidTopic(*topic) {
+		return api.ErrInvalidTopic{Topic: *topic}
+	}
+	//	a log that's open (or crashed) doesn't have a clean manifest; writing
+	//		underneath a running server would corrupt it. Only the topic being
+	//		imported into is opened, so the others are left as they are
+	topicDir := path.Join(*dir, *topic)
+	if _, err := os.Stat(topicDir); err == nil {
+		clean, err := log.CleanlyClosed(topicDir)
+		if err != nil {
+			return err
+		}
+		if !clean {
+			return fmt.Errorf("%s was not shut down cleanly; stop the server first", topicDir)
+		}
+	} else if err := os.MkdirAll(topicDir, 0755); err != nil {
+		return err
+	}
+
+	if c, err = log.FitSegments(topicDir, c); err != nil {
+		return err
+	}
+	l, err := log.NewLog(topicDir, c)
+	if log.IsKeyError(err) {
+		return fmt.Errorf("%s is encrypted: %w; pass the server's -encryption-key-file", topicDir, err)
+	}
+	if err != nil {
+		return err
+	}
+	//	appending in the clear to a topic that's encrypted would leave the
+	//		imported records readable by anyone with the files
+	if next, _ := l.NextOffset(); next > 0 && c.Store.Encryption.Keys == nil {
+		_, err := l.Read(next - 1)
+		if log.IsKeyError(err) {
+			l.Close()
+			return fmt.Errorf("%s is encrypted; pass the server's -encryption-key-file", topicDir)
+		}
+	}
+
+	var batch []*api.Record
+	var imported int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := l.AppendBatch(batch); err != nil {
+			return err
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	add := func(record *api.Record) error {
+		batch = append(batch, record)
+		if len(batch) < importBatch {
+			return nil
+		}
+		return flush()
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		err = parse(os.Stdin, add)
+	}
+	for _, name := range files {
+		if err = importFile(name, parse, add); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && imported > 0 {
+		lowest, _ := l.LowestOffset()
+		highest, _ := l.HighestOffset()
+		fmt.Printf("imported %d records into %s, offsets now %d-%d\n", imported, *topic, lowest, highest)
+	}
+	if cerr := l.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func importFile(name string, parse func(io.Reader, func(*api.Record) error) error, fn func(*api.Record) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parse(f, fn); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+//	lines returns a scanner that takes lines of up to 64MiB
+func lines(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 64<<20)
+	return s
+}
+
+func parseText(r io.Reader, fn func(*api.Record) error) error {
+	s := lines(r)
+	for s.Scan() {
+		value := append([]byte(nil), s.Bytes()...)
+		if err := fn(&api.Record{Value: value}); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+//	parseJSONL keeps each line as it is for the value, after checking it's
+//		JSON, and takes the key and timestamp from its fields when asked to
+func parseJSONL(r io.Reader, keyField, timeField string, fn func(*api.Record) error) error {
+	s := lines(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		record := &api.Record{Value: append([]byte(nil), line...)}
+		if keyField == "" && timeField == "" {
+			if !json.Valid(line) {
+				return fmt.Errorf("line %d: invalid JSON", n)
+			}
+		} else {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(line, &fields); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			if raw, ok := fields[keyField]; ok && keyField != "" {
+				var key string
+				if err := json.Unmarshal(raw, &key); err != nil {
+					return fmt.Errorf("line %d: %s: %w", n, keyField, err)
+				}
+				record.Key = []byte(key)
+			}
+			if raw, ok := fields[timeField]; ok && timeField != "" {
+				ts, err := parseTimestamp(raw)
+				if err != nil {
+					return fmt.Errorf("line %d: %s: %w", n, timeField, err)
+				}
+				record.Timestamp = ts
+			}
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+func parseTimestamp(raw json.RawMessage) (int64, error) {
+	var nanos int64
+	if err := json.Unmarshal(raw, &nanos); err == nil {
+		return nanos, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, fmt.Errorf("want RFC 3339 or unix nanoseconds")
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
+//	parseBinary reads records framed the way the store frames them: a big
+//		endian uint64 length, then the bytes
+func parseBinary(r io.Reader, fn func(*api.Record) error) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		value := make([]byte, binary.BigEndian.Uint64(header))
+		if _, err := io.ReadFull(br, value); err != nil {
+			return fmt.Errorf("truncated record: %w", err)
+		}
+		if err := fn(&api.Record{Value: value}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,230 @@
+//	hydralog runs one node of a hydralog cluster. Settings come from flags,
+//		or from a YAML file named by -config with the same keys as the flags;
+//		flags given on the command line win over the file.
+//
+//	hydralog import bulk-loads files into a stopped log; see runImport
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/NathanClassen/hydralog/internal/agent"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"github.com/NathanClassen/hydralog/internal/tlsconfig"
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	DataDir          string `yaml:"data-dir"`
+	NodeName         string `yaml:"node-name"`
+	BindAddr         string `yaml:"bind-addr"`
+	RPCPort          int    `yaml:"rpc-port"`
+	StartJoinAddrs   addrs  `yaml:"start-join-addrs"`
+	Bootstrap        bool   `yaml:"bootstrap"`
+	MaxStoreBytes    uint64 `yaml:"max-store-bytes"`
+	MaxIndexBytes    uint64 `yaml:"max-index-bytes"`
+	Compression      string `yaml:"compression"`
+	DecodeCacheBytes uint64 `yaml:"decode-cache-bytes"`
+	RaftCompress     bool   `yaml:"raft-compress"`
+	PeerBytesPerSec  uint64 `yaml:"peer-bytes-per-second"`
+	ACLPolicyFile    string `yaml:"acl-policy-file"`
+	PrincipalMapFile string `yaml:"principal-map-file"`
+
+	IndexIntervalBytes uint64 `yaml:"index-interval-bytes"`
+
+	MemoryBytes uint64  `yaml:"memory-bytes"`
+	CPUs        float64 `yaml:"cpus"`
+
+	ServerTLSCertFile string `yaml:"server-tls-cert-file"`
+	ServerTLSKeyFile  string `yaml:"server-tls-key-file"`
+	ServerTLSCAFile   string `yaml:"server-tls-ca-file"`
+	PeerTLSCertFile   string `yaml:"peer-tls-cert-file"`
+	PeerTLSKeyFile    string `yaml:"peer-tls-key-file"`
+	PeerTLSCAFile     string `yaml:"peer-tls-ca-file"`
+	EncryptionKeyFile string `yaml:"encryption-key-file"`
+}
+
+//	addrs is a comma separated flag, or a list in the config file
+type addrs []string
+
+func (a *addrs) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *addrs) Set(v string) error {
+	*a = nil
+	if v != "" {
+		*a = strings.Split(v, ",")
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "hydralog import: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	c, err := parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hydralog: %v\n", err)
+		os.Exit(2)
+	}
+	ac, err := c.agentConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hydralog: %v\n", err)
+		os.Exit(1)
+	}
+	a, err := agent.New(ac)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hydralog: %v\n", err)
+		os.Exit(1)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+	if err := a.Shutdown(); err != nil {
+		fmt.Fprintf(os.Stderr, "hydralog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parse(args []string) (*config, error) {
+	hostname, _ := os.Hostname()
+	c := &config{}
+	fs := flag.NewFlagSet("hydralog", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML file to read settings from")
+	fs.StringVar(&c.DataDir, "data-dir", path.Join(os.TempDir(), "hydralog"), "directory to store the log and raft data in")
+	fs.StringVar(&c.NodeName, "node-name", hostname, "unique name of this node in the cluster")
+	fs.StringVar(&c.BindAddr, "bind-addr", "127.0.0.1:8401", "address serf gossips on; RPCs are served on its host")
+	fs.IntVar(&c.RPCPort, "rpc-port", 8400, "port for the gRPC server and raft")
+	fs.Var(&c.StartJoinAddrs, "start-join-addrs", "comma separated serf addresses of members to join")
+	fs.BoolVar(&c.Bootstrap, "bootstrap", false, "start a new cluster")
+	storeFlags(fs, c)
+	fs.Uint64Var(&c.DecodeCacheBytes, "decode-cache-bytes", 0, "bytes of compressed or encrypted records to keep decoded for repeat reads; 0 for no cache")
+	fs.BoolVar(&c.RaftCompress, "raft-compress", false, "compress raft traffic to other nodes with zstd")
+	fs.Uint64Var(&c.PeerBytesPerSec, "peer-bytes-per-second", 0, "most bytes a second of raft traffic sent to each other node; 0 for no limit")
+	fs.Uint64Var(&c.MemoryBytes, "memory-bytes", 0, "memory the node may use; 0 reads the cgroup's limit")
+	fs.Float64Var(&c.CPUs, "cpus", 0, "CPUs the node may use; 0 reads the cgroup's quota")
+	fs.StringVar(&c.ACLPolicyFile, "acl-policy-file", "", "subject,topic,action policy, with produce, consume or admin actions; empty allows everything")
+	fs.StringVar(&c.PrincipalMapFile, "principal-map-file", "", "attribute,pattern,principal rules for client certificates; empty uses their common names")
+	fs.StringVar(&c.ServerTLSCertFile, "server-tls-cert-file", "", "certificate the server presents")
+	fs.StringVar(&c.ServerTLSKeyFile, "server-tls-key-file", "", "key for the server certificate")
+	fs.StringVar(&c.ServerTLSCAFile, "server-tls-ca-file", "", "CA client certificates must be signed by")
+	fs.StringVar(&c.PeerTLSCertFile, "peer-tls-cert-file", "", "certificate presented to other nodes")
+	fs.StringVar(&c.PeerTLSKeyFile, "peer-tls-key-file", "", "key for the peer certificate")
+	fs.StringVar(&c.PeerTLSCAFile, "peer-tls-ca-file", "", "CA other nodes' certificates must be signed by")
+	if err := parseWithConfigFile(fs, args, configFile, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//	storeFlags registers the flags for how records are stored, which import
+//		shares with the server so what it writes matches
+func storeFlags(fs *flag.FlagSet, c *config) {
+	fs.Uint64Var(&c.MaxStoreBytes, "max-store-bytes", 0, "size a segment's store rolls over at; 0 for the default")
+	fs.Uint64Var(&c.MaxIndexBytes, "max-index-bytes", 0, "size a segment's index rolls over at; 0 for the default")
+	fs.Uint64Var(&c.IndexIntervalBytes, "index-interval-bytes", 0, "store bytes between index entries; 0 indexes every record")
+	fs.StringVar(&c.Compression, "compression", "none", "codec new records are stored with: none, gzip, snappy or zstd")
+	fs.StringVar(&c.EncryptionKeyFile, "encryption-key-file", "", "file holding a raw 16, 24 or 32 byte AES key to encrypt new records with; empty stores them in the clear")
+}
+
+//	parseWithConfigFile parses args into fs, reading the YAML file the
+//		configFile flag names into c in between, so the command line
+//		overrides the file
+func parseWithConfigFile(fs *flag.FlagSet, args []string, configFile *string, c *config) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("%s: %w", *configFile, err)
+	}
+	return fs.Parse(args)
+}
+
+//	logConfig is how the topics are stored
+func (c *config) logConfig() (log.Config, error) {
+	lc := log.Config{}
+	lc.Segment.MaxStoreBytes = c.MaxStoreBytes
+	lc.Segment.MaxIndexBytes = c.MaxIndexBytes
+	lc.Segment.IndexIntervalBytes = c.IndexIntervalBytes
+	lc.Store.DecodeCacheBytes = c.DecodeCacheBytes
+	var err error
+	if lc.Store.Compression, err = log.ParseCompression(c.Compression); err != nil {
+		return lc, err
+	}
+	if c.EncryptionKeyFile != "" {
+		if lc.Store.Encryption.Keys, err = log.ReadKeyFile(c.EncryptionKeyFile); err != nil {
+			return lc, err
+		}
+	}
+	return lc, nil
+}
+
+func (c *config) agentConfig() (agent.Config, error) {
+	ac := agent.Config{
+		DataDir:          c.DataDir,
+		BindAddr:         c.BindAddr,
+		RPCPort:          c.RPCPort,
+		NodeName:         c.NodeName,
+		StartJoinAddrs:   c.StartJoinAddrs,
+		Bootstrap:        c.Bootstrap,
+		ACLPolicyFile:    c.ACLPolicyFile,
+		PrincipalMapFile: c.PrincipalMapFile,
+		Logger:           slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}
+	var err error
+	if ac.Log, err = c.logConfig(); err != nil {
+		return ac, err
+	}
+	ac.Log.Raft.Compress = c.RaftCompress
+	ac.Log.Raft.PeerBytesPerSecond = c.PeerBytesPerSec
+	ac.Resources.MemoryBytes = c.MemoryBytes
+	ac.Resources.CPUs = c.CPUs
+
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return ac, err
+	}
+	if c.ServerTLSCertFile != "" {
+		if ac.ServerTLSConfig, err = tlsconfig.SetupTLSConfig(tlsconfig.Config{
+			CertFile:      c.ServerTLSCertFile,
+			KeyFile:       c.ServerTLSKeyFile,
+			CAFile:        c.ServerTLSCAFile,
+			ServerAddress: host,
+			Server:        true,
+		}); err != nil {
+			return ac, err
+		}
+	}
+	if c.PeerTLSCertFile != "" {
+		if ac.PeerTLSConfig, err = tlsconfig.SetupTLSConfig(tlsconfig.Config{
+			CertFile:      c.PeerTLSCertFile,
+			KeyFile:       c.PeerTLSKeyFile,
+			CAFile:        c.PeerTLSCAFile,
+			ServerAddress: host,
+		}); err != nil {
+			return ac, err
+		}
+	}
+	return ac, nil
+}
@@ -0,0 +1,291 @@
+//	hydralogctl talks to a running hydralog server over its gRPC API, for
+//		operators poking at a node by hand:
+//
+//	hydralogctl [flags] produce [-topic T] [-key K] [value ...]
+//	hydralogctl [flags] consume [-topic T] [-offset N] [-n COUNT]
+//	hydralogctl [flags] tail [-topic T] [-from N]
+//	hydralogctl [flags] offsets [-topic T]
+//	hydralogctl [flags] segments list [-topic T]
+//	hydralogctl [flags] truncate [-topic T] -lowest N
+//
+//	Records are printed one per line as their offset, a tab and the value
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"github.com/NathanClassen/hydralog/internal/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+//	command runs a subcommand against client with what's left of the
+//		command line
+type command func(ctx context.Context, client api.LogClient, args []string) error
+
+var commands = map[string]command{
+	"produce":  produce,
+	"consume":  consume,
+	"tail":     tail,
+	"offsets":  offsets,
+	"segments": segments,
+	"truncate": truncate,
+}
+
+func main() {
+	fs := flag.NewFlagSet("hydralogctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hydralogctl [flags] produce|consume|tail|offsets|segments list|truncate [args]")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "127.0.0.1:8400", "address of the server's gRPC port")
+	certFile := fs.String("tls-cert-file", "", "client certificate, for servers that ask for one")
+	keyFile := fs.String("tls-key-file", "", "key for the client certificate")
+	caFile := fs.String("tls-ca-file", "", "CA the server's certificate is signed by; empty connects in plaintext")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "hydralogctl: unknown command %q\n", fs.Arg(0))
+		os.Exit(2)
+	}
+
+	creds := insecure.NewCredentials()
+	if *caFile != "" {
+		host, _, err := net.SplitHostPort(*addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hydralogctl: %v\n", err)
+			os.Exit(2)
+		}
+		tlsConfig, err := tlsconfig.SetupTLSConfig(tlsconfig.Config{
+			CertFile:      *certFile,
+			KeyFile:       *keyFile,
+			CAFile:        *caFile,
+			ServerAddress: host,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hydralogctl: %v\n", err)
+			os.Exit(1)
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	cc, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hydralogctl: %v\n", err)
+		os.Exit(1)
+	}
+	defer cc.Close()
+
+	//	tail runs until it's interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := cmd(ctx, api.NewLogClient(cc), fs.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "hydralogctl %s: %v\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+//	subcommand returns a flag set for name with the -topic flag every
+//		subcommand has
+func subcommand(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("hydralogctl "+name, flag.ContinueOnError)
+	topic := fs.String("topic", log.DefaultTopic, "topic to use")
+	return fs, topic
+}
+
+//	produce appends its arguments as records, or each line of stdin when
+//		there are none
+func produce(ctx context.Context, client api.LogClient, args []string) error {
+	fs, topic := subcommand("produce")
+	key := fs.String("key", "", "key to give every record")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	send := func(value []byte) error {
+		res, err := client.Produce(ctx, &api.ProduceRequest{
+			Topic:  *topic,
+			Record: &api.Record{Value: value, Key: []byte(*key)},
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(res.Offset)
+		return nil
+	}
+	if fs.NArg() > 0 {
+		for _, value := range fs.Args() {
+			if err := send([]byte(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s := bufio.NewScanner(os.Stdin)
+	s.Buffer(make([]byte, 64*1024), 64<<20)
+	for s.Scan() {
+		if err := send(append([]byte(nil), s.Bytes()...)); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+//	consume prints count records from offset on, stopping early at the end
+//		of the topic
+func consume(ctx context.Context, client api.LogClient, args []string) error {
+	fs, topic := subcommand("consume")
+	offset := fs.Uint64("offset", 0, "first offset to read")
+	count := fs.Uint64("n", 1, "number of records to read")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *count == 0 {
+		return nil
+	}
+	next, err := nextOffset(ctx, client, *topic)
+	if err != nil {
+		return err
+	}
+	if *offset >= next {
+		return nil
+	}
+	until := min(next-1, *offset+*count-1)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Topic:       *topic,
+		Offset:      *offset,
+		UntilOffset: &until,
+	})
+	if err != nil {
+		return err
+	}
+	return printRecords(stream)
+}
+
+//	tail follows a topic, printing records as they're appended, until it's
+//		interrupted
+func tail(ctx context.Context, client api.LogClient, args []string) error {
+	fs, topic := subcommand("tail")
+	from := fs.Int64("from", -1, "offset to start at; -1 for the next record appended")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	req := &api.ConsumeRequest{Topic: *topic}
+	if *from >= 0 {
+		req.Offset = uint64(*from)
+	} else {
+		next, err := nextOffset(ctx, client, *topic)
+		if err != nil {
+			return err
+		}
+		req.Offset = next
+	}
+	stream, err := client.ConsumeStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	err = printRecords(stream)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+//	nextOffset is the offset topic's next record will get
+func nextOffset(ctx context.Context, client api.LogClient, topic string) (uint64, error) {
+	res, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{Topic: topic})
+	if err != nil {
+		return 0, err
+	}
+	return res.NextOffset, nil
+}
+
+func printRecords(stream api.Log_ConsumeStreamClient) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%s\n", res.Record.Offset, res.Record.Value)
+		//	a tail should show records as they come
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func offsets(ctx context.Context, client api.LogClient, args []string) error {
+	fs, topic := subcommand("offsets")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	res, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{Topic: *topic})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("lowest\t%d\nhighest\t%d\nnext\t%d\n", res.LowestOffset, res.HighestOffset, res.NextOffset)
+	return nil
+}
+
+func segments(ctx context.Context, client api.LogClient, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("usage: segments list [-topic T]")
+	}
+	fs, topic := subcommand("segments list")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	res, err := client.GetSegments(ctx, &api.GetSegmentsRequest{Topic: *topic})
+	if err != nil {
+		return err
+	}
+	fmt.Println("base\tnext\tstore_bytes\tindex_bytes")
+	for _, s := range res.Segments {
+		fmt.Printf("%d\t%d\t%d\t%d\n", s.BaseOffset, s.NextOffset, s.StoreBytes, s.IndexBytes)
+	}
+	return nil
+}
+
+func truncate(ctx context.Context, client api.LogClient, args []string) error {
+	fs, topic := subcommand("truncate")
+	lowest := fs.Int64("lowest", -1, "remove the segments whose records are all at or below this offset")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *lowest < 0 {
+		return fmt.Errorf("-lowest is required")
+	}
+	res, err := client.TruncateTopic(ctx, &api.TruncateTopicRequest{
+		Topic:  *topic,
+		Lowest: uint64(*lowest),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("lowest offset now %d\n", res.Lowest)
+	return nil
+}
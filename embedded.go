@@ -0,0 +1,121 @@
+package hydralog
+
+import (
+	"context"
+	"net"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"github.com/NathanClassen/hydralog/internal/server"
+)
+
+type Config struct {
+	//	where the topics are stored
+	DataDir string
+	Log     log.Config
+	//	serve the log over gRPC on this address as well, e.g. ":8400"; empty
+	//		keeps it private to the process
+	BindAddr string
+	//	settings for that server. CommitLog is filled in by NewEmbedded
+	Server server.Config
+}
+
+//	Embedded runs hydralog inside another process. Produce and Consume go
+//		straight to the log; other processes can use the same data through
+//		the optional gRPC server, so an application can start out local and
+//		move to a shared deployment later
+type Embedded struct {
+	topics   *log.Topics
+	server   *server.Server
+	listener net.Listener
+}
+
+func NewEmbedded(c Config) (*Embedded, error) {
+	topics, err := log.NewTopics(c.DataDir, c.Log)
+	if err != nil {
+		return nil, err
+	}
+	e := &Embedded{topics: topics}
+	if c.BindAddr == "" {
+		return e, nil
+	}
+
+	c.Server.CommitLog = topics
+	if e.server, err = server.NewGRPCServer(&c.Server); err != nil {
+		topics.Close()
+		return nil, err
+	}
+	if e.listener, err = net.Listen("tcp", c.BindAddr); err != nil {
+		topics.Close()
+		return nil, err
+	}
+	go func() {
+		_ = e.server.Serve(e.listener)
+	}()
+	return e, nil
+}
+
+//	Produce appends a record to topic, creating the topic if needed, and
+//		returns its offset. An empty topic is the default one
+func (e *Embedded) Produce(topic string, record *api.Record) (uint64, error) {
+	return e.topics.Append(topic, record)
+}
+
+//	ProduceBatch appends records to topic in order and returns their offsets
+func (e *Embedded) ProduceBatch(topic string, records []*api.Record) ([]uint64, error) {
+	return e.topics.AppendBatch(topic, records)
+}
+
+//	Consume reads the record at offset in topic
+func (e *Embedded) Consume(topic string, offset uint64) (*api.Record, error) {
+	return e.topics.Read(topic, offset)
+}
+
+//	Offsets returns the lowest and highest offsets in topic
+func (e *Embedded) Offsets(topic string) (lowest, highest uint64, err error) {
+	return e.topics.Offsets(topic)
+}
+
+//	NextOffset returns the offset topic's next record will get
+func (e *Embedded) NextOffset(topic string) (uint64, error) {
+	return e.topics.NextOffset(topic)
+}
+
+//	OffsetForTime returns the offset of topic's first record at or after
+//		timestamp, in unix nanoseconds
+func (e *Embedded) OffsetForTime(topic string, timestamp int64) (uint64, error) {
+	return e.topics.OffsetForTime(topic, timestamp)
+}
+
+//	CloneTopic copies topic into a new topic clone, as it stands now, and
+//		returns the highest offset the clone got
+func (e *Embedded) CloneTopic(topic, clone string) (uint64, error) {
+	return e.topics.CloneTopic(topic, clone)
+}
+
+//	Segments describes topic's segments, oldest first
+func (e *Embedded) Segments(topic string) ([]log.SegmentInfo, error) {
+	return e.topics.Segments(topic)
+}
+
+//	Truncate removes topic's segments whose records are all at or below
+//		lowest and returns its lowest offset afterwards
+func (e *Embedded) Truncate(topic string, lowest uint64) (uint64, error) {
+	return e.topics.Truncate(topic, lowest)
+}
+
+//	Addr is the address the gRPC server listens on, or nil without one
+func (e *Embedded) Addr() net.Addr {
+	if e.listener == nil {
+		return nil
+	}
+	return e.listener.Addr()
+}
+
+//	Close drains the server, if there is one, and closes the log
+func (e *Embedded) Close() error {
+	if e.server != nil {
+		return e.server.Shutdown(context.Background())
+	}
+	return e.topics.Close()
+}
@@ -0,0 +1,52 @@
+package hydralog
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestEmbedded(t *testing.T) {
+	dir, err := os.MkdirTemp("", "embedded-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e, err := NewEmbedded(Config{DataDir: dir, BindAddr: "127.0.0.1:0"})
+	require.NoError(t, err)
+
+	want := []byte("hello world")
+	off, err := e.Produce("", &api.Record{Value: want})
+	require.NoError(t, err)
+	record, err := e.Consume("", off)
+	require.NoError(t, err)
+	require.Equal(t, want, record.Value)
+
+	// the same record is there for other processes over gRPC
+	cc, err := grpc.NewClient(
+		e.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer cc.Close()
+	res, err := api.NewLogClient(cc).Consume(
+		context.Background(),
+		&api.ConsumeRequest{Offset: off},
+	)
+	require.NoError(t, err)
+	require.Equal(t, want, res.Record.Value)
+	require.NoError(t, e.Close())
+
+	// and still there after a restart, now without a server
+	e, err = NewEmbedded(Config{DataDir: dir})
+	require.NoError(t, err)
+	require.Nil(t, e.Addr())
+	record, err = e.Consume("", off)
+	require.NoError(t, err)
+	require.Equal(t, want, record.Value)
+	require.NoError(t, e.Close())
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NathanClassen/hydralog/internal/auth"
+	"github.com/NathanClassen/hydralog/internal/discovery"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"github.com/NathanClassen/hydralog/internal/server"
+	"github.com/hashicorp/raft"
+	"github.com/soheilhy/cmux"
+)
+
+type Config struct {
+	//	where the topics and raft's state are stored
+	DataDir string
+	//	address serf gossips on; its host is also where RPCs are served
+	BindAddr string
+	//	port the gRPC server and raft share
+	RPCPort int
+	//	unique name of this node in the cluster, also its raft id
+	NodeName string
+	//	serf addresses of existing members to join
+	StartJoinAddrs []string
+	//	start a new cluster with this node as its only voter. Only the first
+	//		node of a cluster should bootstrap
+	Bootstrap bool
+	//	settings for the topics. The agent fills in Raft's transport, id and
+	//		Bootstrap; the rest of Raft is passed on as is
+	Log log.Config
+	//	TLS for clients and for raft traffic from other nodes, and for raft
+	//		traffic to them. nil is plaintext
+	ServerTLSConfig *tls.Config
+	PeerTLSConfig   *tls.Config
+	//	policy file for the server's Authorizer (see auth.New); empty lets
+	//		everyone do everything
+	ACLPolicyFile string
+	//	rules mapping client certificates to the principals the policy
+	//		names (see auth.NewPrincipals); empty uses their common names
+	PrincipalMapFile string
+	//	memory and CPUs the node may use; what's left 0 is read from the
+	//		process's cgroup, and with no cgroup limit either the node
+	//		assumes the whole machine
+	Resources Resources
+	//	where the log, the server and membership log to, with the node's
+	//		name attached. nil logs only membership errors, to slog.Default()
+	Logger *slog.Logger
+}
+
+//	logger is Logger tagged with the node and the component, or nil
+func (c Config) logger(component string) *slog.Logger {
+	if c.Logger == nil {
+		return nil
+	}
+	return c.Logger.With("node", c.NodeName, "component", component)
+}
+
+//	RPCAddr is the address the gRPC server and raft listen on
+func (c Config) RPCAddr() (string, error) {
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, c.RPCPort), nil
+}
+
+//	Agent runs one node of a cluster: the replicated log, the gRPC server in
+//		front of it, and the membership that adds other nodes to raft as
+//		they join
+type Agent struct {
+	Config
+	mux        cmux.CMux
+	log        *log.DistributedLog
+	server     *server.Server
+	membership *discovery.Membership
+
+	shutdown     bool
+	shutdownLock sync.Mutex
+}
+
+func New(config Config) (*Agent, error) {
+	a := &Agent{Config: config}
+	setup := []func() error{
+		a.setupResources,
+		a.setupMux,
+		a.setupLog,
+		a.setupServer,
+		a.setupMembership,
+	}
+	for _, fn := range setup {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	go a.serve()
+	return a, nil
+}
+
+func (a *Agent) setupMux() error {
+	rpcAddr, err := a.RPCAddr()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", rpcAddr)
+	if err != nil {
+		return err
+	}
+	a.mux = cmux.New(ln)
+	return nil
+}
+
+func (a *Agent) setupLog() error {
+	//	raft connections say so with their first byte; everything else is gRPC
+	raftLn := a.mux.Match(func(r io.Reader) bool {
+		b := make([]byte, 1)
+		if _, err := r.Read(b); err != nil {
+			return false
+		}
+		return b[0] == log.RaftRPC || b[0] == log.RaftRPCCompressed
+	})
+	c := a.Config.Log
+	c.Raft.StreamLayer = log.NewStreamLayer(
+		raftLn,
+		a.Config.ServerTLSConfig,
+		a.Config.PeerTLSConfig,
+	)
+	c.Raft.LocalID = raft.ServerID(a.Config.NodeName)
+	c.Raft.Bootstrap = a.Config.Bootstrap
+	if c.Logger == nil {
+		c.Logger = a.Config.logger("log")
+	}
+	var err error
+	if a.log, err = log.NewDistributedLog(a.Config.DataDir, c); err != nil {
+		return err
+	}
+	if a.Config.Bootstrap {
+		return a.log.WaitForLeader(3 * time.Second)
+	}
+	return nil
+}
+
+func (a *Agent) setupServer() error {
+	c := &server.Config{
+		CommitLog:    a.log,
+		ServerGetter: a.log,
+		TLS:          a.Config.ServerTLSConfig,
+		Logger:       a.Config.logger("server"),
+	}
+	//	recent history for GetMetrics, for operators without a metrics system
+	c.Metrics.Interval = 10 * time.Second
+	if a.Config.ACLPolicyFile != "" {
+		authorizer, err := auth.New(a.Config.ACLPolicyFile)
+		if err != nil {
+			return err
+		}
+		c.Authorizer = authorizer
+	}
+	if a.Config.PrincipalMapFile != "" {
+		principals, err := auth.NewPrincipals(a.Config.PrincipalMapFile)
+		if err != nil {
+			return err
+		}
+		c.PrincipalMapper = principals
+	}
+	var err error
+	if a.server, err = server.NewGRPCServer(c); err != nil {
+		return err
+	}
+	grpcLn := a.mux.Match(cmux.Any())
+	go func() {
+		_ = a.server.Serve(grpcLn)
+	}()
+	return nil
+}
+
+func (a *Agent) setupMembership() error {
+	rpcAddr, err := a.RPCAddr()
+	if err != nil {
+		return err
+	}
+	a.membership, err = discovery.New(a.log, discovery.Config{
+		NodeName: a.Config.NodeName,
+		BindAddr: a.Config.BindAddr,
+		Tags: map[string]string{
+			"rpc_addr": rpcAddr,
+		},
+		StartJoinAddrs: a.Config.StartJoinAddrs,
+		Logger:         a.Config.logger("membership"),
+	})
+	return err
+}
+
+//	serve hands out connections until the server closes the listener
+func (a *Agent) serve() {
+	_ = a.mux.Serve()
+}
+
+//	Shutdown leaves the cluster, drains the server and closes the log. It's
+//		safe to call more than once
+func (a *Agent) Shutdown() error {
+	a.shutdownLock.Lock()
+	defer a.shutdownLock.Unlock()
+	if a.shutdown {
+		return nil
+	}
+	a.shutdown = true
+
+	shutdown := []func() error{
+		a.membership.Leave,
+		//	closes the log once the RPCs in flight are done
+		func() error {
+			return a.server.Shutdown(context.Background())
+		},
+	}
+	for _, fn := range shutdown {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
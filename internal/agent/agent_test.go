@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAgent(t *testing.T) {
+	var agents []*Agent
+	for i := 0; i < 3; i++ {
+		dataDir, err := os.MkdirTemp("", "agent-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dataDir)
+
+		c := Config{
+			DataDir:   dataDir,
+			BindAddr:  fmt.Sprintf("127.0.0.1:%d", freePort(t)),
+			RPCPort:   freePort(t),
+			NodeName:  fmt.Sprintf("%d", i),
+			Bootstrap: i == 0,
+		}
+		c.Log.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		c.Log.Raft.ElectionTimeout = 50 * time.Millisecond
+		c.Log.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		c.Log.Raft.CommitTimeout = 5 * time.Millisecond
+		if i != 0 {
+			c.StartJoinAddrs = []string{agents[0].BindAddr}
+		}
+		a, err := New(c)
+		require.NoError(t, err)
+		agents = append(agents, a)
+	}
+	defer func() {
+		for _, a := range agents {
+			require.NoError(t, a.Shutdown())
+		}
+	}()
+
+	ctx := context.Background()
+	leader := client(t, agents[0])
+	res, err := leader.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("foo")},
+	})
+	require.NoError(t, err)
+
+	// the followers get it once raft has replicated it
+	for _, a := range agents[1:] {
+		follower := client(t, a)
+		require.Eventually(t, func() bool {
+			got, err := follower.Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+			return err == nil && string(got.Record.Value) == "foo"
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+
+	// linearizable reads are only served by the leader
+	got, err := leader.Consume(ctx, &api.ConsumeRequest{Offset: res.Offset, Linearizable: true})
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(got.Record.Value))
+	_, err = client(t, agents[1]).Consume(ctx, &api.ConsumeRequest{Offset: res.Offset, Linearizable: true})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	servers, err := leader.GetServers(ctx, &api.GetServersRequest{})
+	require.NoError(t, err)
+	require.Len(t, servers.Servers, 3)
+	for _, srv := range servers.Servers {
+		require.Equal(t, srv.Id == "0", srv.IsLeader)
+	}
+}
+
+func client(t *testing.T, a *Agent) api.LogClient {
+	t.Helper()
+	rpcAddr, err := a.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(
+		rpcAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return api.NewLogClient(conn)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
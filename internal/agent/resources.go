@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+//	Resources is how much of the machine a node may use. Containers usually
+//		get less than the whole machine, which the Go runtime (and the
+//		defaults sized from it) doesn't know about by itself
+type Resources struct {
+	//	bytes of memory; the runtime's soft limit is set a little under it
+	//		and the decode cache is held to an eighth of it
+	MemoryBytes uint64
+	//	CPUs, which may be fractional; GOMAXPROCS is rounded up from it
+	CPUs float64
+}
+
+//	where the cgroup filesystems are mounted, and the file that says which
+//		cgroup this process is in
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	selfCgroup = "/proc/self/cgroup"
+)
+
+//	cgroup v1 reports no memory limit as a huge number rather than "max"
+const noMemoryLimit = 1 << 62
+
+//	setupResources works out the node's limits, from the config where it
+//		gives them and from its cgroup otherwise, and fits the runtime and
+//		the log's decode cache to them. GOMAXPROCS and GOMEMLIMIT set in the
+//		environment are left alone
+func (a *Agent) setupResources() error {
+	self, err := os.ReadFile(selfCgroup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r := detectResources(cgroupRoot, self)
+	if a.Config.Resources.MemoryBytes > 0 {
+		r.MemoryBytes = a.Config.Resources.MemoryBytes
+	}
+	if a.Config.Resources.CPUs > 0 {
+		r.CPUs = a.Config.Resources.CPUs
+	}
+	a.Config.Resources = r
+
+	if r.CPUs > 0 && os.Getenv("GOMAXPROCS") == "" {
+		if n := int(math.Ceil(r.CPUs)); n < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(n)
+		}
+	}
+	if r.MemoryBytes > 0 {
+		if os.Getenv("GOMEMLIMIT") == "" {
+			debug.SetMemoryLimit(int64(r.MemoryBytes / 10 * 9))
+		}
+		if max := r.MemoryBytes / 8; a.Config.Log.Store.DecodeCacheBytes > max {
+			a.Config.Log.Store.DecodeCacheBytes = max
+		}
+	}
+	if logger := a.Config.logger("agent"); logger != nil {
+		logger.Info("resource limits",
+			"memory_bytes", r.MemoryBytes,
+			"cpus", r.CPUs,
+			"gomaxprocs", runtime.GOMAXPROCS(0),
+		)
+	}
+	return nil
+}
+
+//	detectResources reads the memory and CPU limits of the cgroup self (the
+//		contents of /proc/self/cgroup) names, from the cgroup filesystems
+//		under root. Limits set on a parent cgroup apply too, so the tightest
+//		one on the way up wins. Anything it can't find is left 0, no limit
+func detectResources(root string, self []byte) Resources {
+	var r Resources
+	s := bufio.NewScanner(bytes.NewReader(self))
+	for s.Scan() {
+		//	hierarchy-id:controllers:path
+		fields := strings.SplitN(s.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		controllers, cgroup := strings.Split(fields[1], ","), fields[2]
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			walk(root, cgroup, func(dir string) {
+				r.MemoryBytes = tighter(r.MemoryBytes, readMemoryMax(path.Join(dir, "memory.max")))
+				r.CPUs = tighterCPUs(r.CPUs, readCPUMax(path.Join(dir, "cpu.max")))
+			})
+		case has(controllers, "memory"):
+			walk(path.Join(root, "memory"), cgroup, func(dir string) {
+				r.MemoryBytes = tighter(r.MemoryBytes, readMemoryMax(path.Join(dir, "memory.limit_in_bytes")))
+			})
+		case has(controllers, "cpu"):
+			dirs := []string{path.Join(root, "cpu"), path.Join(root, "cpu,cpuacct")}
+			for _, base := range dirs {
+				walk(base, cgroup, func(dir string) {
+					r.CPUs = tighterCPUs(r.CPUs, readCFSQuota(dir))
+				})
+			}
+		}
+	}
+	return r
+}
+
+//	walk calls fn with the directory of cgroup under base and each of its
+//		parents up to base. Inside a container the path is often the host's
+//		and doesn't exist; the container's own cgroup is then base itself
+func walk(base, cgroup string, fn func(dir string)) {
+	dir := path.Join(base, cgroup)
+	if _, err := os.Stat(dir); err != nil {
+		dir = base
+	}
+	for {
+		fn(dir)
+		if dir == base || !strings.HasPrefix(dir, base) {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func has(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//	tighter returns the smaller limit, where 0 is no limit
+func tighter(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func tighterCPUs(a, b float64) float64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+//	readMemoryMax reads a memory limit in bytes; "max", a missing file and
+//		v1's stand-in for no limit are all 0
+func readMemoryMax(name string) uint64 {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || n >= noMemoryLimit {
+		return 0
+	}
+	return n
+}
+
+//	readCPUMax reads cgroup v2's "quota period", in microseconds, as CPUs
+func readCPUMax(name string) float64 {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0
+	}
+	return cpus(fields[0], fields[1])
+}
+
+//	readCFSQuota reads cgroup v1's quota and period out of dir as CPUs
+func readCFSQuota(dir string) float64 {
+	quota, err := os.ReadFile(path.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0
+	}
+	period, err := os.ReadFile(path.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0
+	}
+	return cpus(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+//	cpus divides a quota by its period; "max" and v1's -1 are no limit
+func cpus(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectResources(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		files map[string]string
+		self  string
+		want  Resources
+	}{
+		"v2": {
+			files: map[string]string{
+				"kubepods/memory.max":     "max\n",
+				"kubepods/cpu.max":        "400000 100000\n",
+				"kubepods/pod/memory.max": "536870912\n",
+				"kubepods/pod/cpu.max":    "max 100000\n",
+			},
+			self: "0::/kubepods/pod\n",
+			want: Resources{MemoryBytes: 512 << 20, CPUs: 4},
+		},
+		"v2 in a cgroup namespace": {
+			files: map[string]string{
+				"memory.max": "1073741824\n",
+				"cpu.max":    "150000 100000\n",
+			},
+			self: "0::/host/path/we/cannot/see\n",
+			want: Resources{MemoryBytes: 1 << 30, CPUs: 1.5},
+		},
+		"v1": {
+			files: map[string]string{
+				"memory/docker/abc/memory.limit_in_bytes":  "268435456\n",
+				"cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "50000\n",
+				"cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000\n",
+			},
+			self: "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n",
+			want: Resources{MemoryBytes: 256 << 20, CPUs: 0.5},
+		},
+		"v1 unlimited": {
+			files: map[string]string{
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+			},
+			self: "12:memory:/\n4:cpu,cpuacct:/\n",
+			want: Resources{},
+		},
+		"no cgroup": {
+			want: Resources{},
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			root := t.TempDir()
+			for name, contents := range tc.files {
+				name = path.Join(root, name)
+				require.NoError(t, os.MkdirAll(path.Dir(name), 0755))
+				require.NoError(t, os.WriteFile(name, []byte(contents), 0644))
+			}
+			require.Equal(t, tc.want, detectResources(root, []byte(tc.self)))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	matches any subject, topic or action in a policy
+const wildcard = "*"
+
+//	actions a policy can grant on a topic
+const (
+	//	appending records, and creating the topic by doing so
+	ActionProduce = "produce"
+	//	reading records and offsets
+	ActionConsume = "consume"
+	//	destroying data that's been written, like truncating the topic. It
+	//		isn't implied by produce
+	ActionAdmin = "admin"
+)
+
+type rule struct {
+	subject, topic, action string
+}
+
+//	Authorizer grants subjects actions on topics according to a policy file.
+//		Each line of the file is one grant, as subject,topic,action, where the
+//		action is produce, consume or admin; any field may be * and lines
+//		starting with # are comments. Anything not granted is denied
+type Authorizer struct {
+	rules []rule
+}
+
+func New(policyFile string) (*Authorizer, error) {
+	f, err := os.Open(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+//	Parse reads a policy in the same format as New
+func Parse(r io.Reader) (*Authorizer, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	lines, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("auth: bad policy: %w", err)
+	}
+	a := &Authorizer{}
+	for _, l := range lines {
+		a.rules = append(a.rules, rule{subject: l[0], topic: l[1], action: l[2]})
+	}
+	return a, nil
+}
+
+//	Authorize returns a PermissionDenied error unless the policy lets subject
+//		carry out action on topic
+func (a *Authorizer) Authorize(subject, topic, action string) error {
+	match := func(want, got string) bool {
+		return want == wildcard || want == got
+	}
+	for _, r := range a.rules {
+		if match(r.subject, subject) && match(r.topic, topic) && match(r.action, action) {
+			return nil
+		}
+	}
+	return status.Errorf(
+		codes.PermissionDenied,
+		"%q is not permitted to %s on topic %q", subject, action, topic,
+	)
+}
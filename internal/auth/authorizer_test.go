@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizer(t *testing.T) {
+	a, err := Parse(strings.NewReader(`
+# subject, topic, action
+root, *, *
+billing, invoices, produce
+*, invoices, consume
+`))
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		subject, topic, action string
+		allowed                bool
+	}{
+		{"root", "anything", "produce", true},
+		{"billing", "invoices", "produce", true},
+		{"billing", "orders", "produce", false},
+		{"reports", "invoices", "consume", true},
+		{"reports", "invoices", "produce", false},
+		{"", "invoices", "consume", true},
+	} {
+		err := a.Authorize(c.subject, c.topic, c.action)
+		if c.allowed {
+			require.NoError(t, err, c)
+		} else {
+			require.Equal(t, codes.PermissionDenied, status.Code(err), c)
+		}
+	}
+
+	_, err = Parse(strings.NewReader("root, *\n"))
+	require.Error(t, err)
+}
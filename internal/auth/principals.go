@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+//	certificate attributes a principal can be mapped from
+const (
+	attrCN     = "cn"
+	attrOU     = "ou"
+	attrDNS    = "dns"
+	attrEmail  = "email"
+	attrURI    = "uri"
+	attrSPIFFE = "spiffe"
+)
+
+//	a principal of $value is whatever the attribute matched
+const matchedValue = "$value"
+
+type mapping struct {
+	attr, pattern, principal string
+}
+
+//	Principals maps verified client certificates to the principals that ACLs
+//		are written against. Each line of a mapping file is one rule, as
+//		attribute,pattern,principal, tried in order until one matches:
+//
+//		cn, dns, email, uri	the common name or a SAN of that kind
+//		ou			an organizational unit
+//		spiffe			a URI SAN with the spiffe scheme
+//
+//	Patterns are path.Match globs, so * stops at slashes; a principal of
+//		$value is the matched value itself. Lines starting with # are
+//		comments. Certificates no rule matches act as their common name
+type Principals struct {
+	mappings []mapping
+}
+
+func NewPrincipals(mappingFile string) (*Principals, error) {
+	f, err := os.Open(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePrincipals(f)
+}
+
+//	ParsePrincipals reads mapping rules in the same format as NewPrincipals
+func ParsePrincipals(r io.Reader) (*Principals, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	lines, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("auth: bad principal mapping: %w", err)
+	}
+	p := &Principals{}
+	for _, l := range lines {
+		switch l[0] {
+		case attrCN, attrOU, attrDNS, attrEmail, attrURI, attrSPIFFE:
+		default:
+			return nil, fmt.Errorf("auth: bad principal mapping: unknown attribute %q", l[0])
+		}
+		//	reject bad patterns now rather than never matching later
+		if _, err := path.Match(l[1], ""); err != nil {
+			return nil, fmt.Errorf("auth: bad principal mapping: pattern %q: %w", l[1], err)
+		}
+		p.mappings = append(p.mappings, mapping{attr: l[0], pattern: l[1], principal: l[2]})
+	}
+	return p, nil
+}
+
+//	Principal returns the principal cert acts as
+func (p *Principals) Principal(cert *x509.Certificate) string {
+	for _, m := range p.mappings {
+		for _, v := range values(cert, m.attr) {
+			if ok, _ := path.Match(m.pattern, v); !ok {
+				continue
+			}
+			if m.principal == matchedValue {
+				return v
+			}
+			return m.principal
+		}
+	}
+	return cert.Subject.CommonName
+}
+
+func values(cert *x509.Certificate, attr string) []string {
+	switch attr {
+	case attrCN:
+		return []string{cert.Subject.CommonName}
+	case attrOU:
+		return cert.Subject.OrganizationalUnit
+	case attrDNS:
+		return cert.DNSNames
+	case attrEmail:
+		return cert.EmailAddresses
+	}
+	var uris []string
+	for _, u := range cert.URIs {
+		if attr == attrSPIFFE && u.Scheme != "spiffe" {
+			continue
+		}
+		uris = append(uris, u.String())
+	}
+	return uris
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipals(t *testing.T) {
+	p, err := ParsePrincipals(strings.NewReader(`
+# attribute, pattern, principal
+spiffe, spiffe://example.org/ns/prod/*, $value
+ou, payments, payments
+dns, *.billing.svc, billing
+`))
+	require.NoError(t, err)
+
+	spiffe, err := url.Parse("spiffe://example.org/ns/prod/api")
+	require.NoError(t, err)
+	other, err := url.Parse("https://example.org/ns/prod/api")
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		cert *x509.Certificate
+		want string
+	}{
+		{&x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe://example.org/ns/prod/api"},
+		{&x509.Certificate{
+			Subject: pkix.Name{CommonName: "api", OrganizationalUnit: []string{"ops", "payments"}},
+		}, "payments"},
+		{&x509.Certificate{DNSNames: []string{"worker.billing.svc"}}, "billing"},
+		// only spiffe URIs match spiffe rules; no match falls back to the CN
+		{&x509.Certificate{
+			Subject: pkix.Name{CommonName: "api"},
+			URIs:    []*url.URL{other},
+		}, "api"},
+	} {
+		require.Equal(t, c.want, p.Principal(c.cert))
+	}
+
+	_, err = ParsePrincipals(strings.NewReader("serial, *, root\n"))
+	require.Error(t, err)
+	_, err = ParsePrincipals(strings.NewReader("cn, [, root\n"))
+	require.Error(t, err)
+}
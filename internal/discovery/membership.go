@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+)
+
+type Config struct {
+	//	unique name of this node in the cluster
+	NodeName string
+	//	address serf gossips on
+	BindAddr string
+	//	shared with the rest of the cluster, e.g. the node's RPC address
+	Tags map[string]string
+	//	addresses of existing members to join; empty starts a new cluster
+	StartJoinAddrs []string
+	//	where errors handling members are logged; nil uses slog.Default()
+	Logger *slog.Logger
+}
+
+//	Handler is told when servers join or leave the cluster, so the log can
+//		add them to or remove them from replication
+type Handler interface {
+	Join(name, addr string) error
+	Leave(name string) error
+}
+
+//	Membership gossips with the other nodes through Serf and hands every join
+//		and leave to the handler
+type Membership struct {
+	Config
+	handler Handler
+	serf    *serf.Serf
+	events  chan serf.Event
+}
+
+func New(handler Handler, config Config) (*Membership, error) {
+	c := &Membership{
+		Config:  config,
+		handler: handler,
+	}
+	if err := c.setupSerf(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *Membership) setupSerf() error {
+	addr, err := net.ResolveTCPAddr("tcp", m.BindAddr)
+	if err != nil {
+		return err
+	}
+	config := serf.DefaultConfig()
+	config.Init()
+	config.MemberlistConfig.BindAddr = addr.IP.String()
+	config.MemberlistConfig.BindPort = addr.Port
+	m.events = make(chan serf.Event)
+	config.EventCh = m.events
+	config.Tags = m.Tags
+	config.NodeName = m.Config.NodeName
+	if m.serf, err = serf.Create(config); err != nil {
+		return err
+	}
+	go m.eventHandler()
+	if m.StartJoinAddrs != nil {
+		if _, err = m.serf.Join(m.StartJoinAddrs, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Membership) eventHandler() {
+	for e := range m.events {
+		switch e.EventType() {
+		case serf.EventMemberJoin:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					continue
+				}
+				m.handleJoin(member)
+			}
+		case serf.EventMemberLeave, serf.EventMemberFailed:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					//	we're the one leaving; there's nothing left to handle
+					return
+				}
+				m.handleLeave(member)
+			}
+		}
+	}
+}
+
+func (m *Membership) handleJoin(member serf.Member) {
+	if err := m.handler.Join(
+		member.Name,
+		member.Tags["rpc_addr"],
+	); err != nil {
+		m.logError(err, "failed to join", member)
+	}
+}
+
+func (m *Membership) handleLeave(member serf.Member) {
+	if err := m.handler.Leave(member.Name); err != nil {
+		m.logError(err, "failed to leave", member)
+	}
+}
+
+func (m *Membership) isLocal(member serf.Member) bool {
+	return m.serf.LocalMember().Name == member.Name
+}
+
+//	Members returns a point-in-time snapshot of the cluster's members
+func (m *Membership) Members() []serf.Member {
+	return m.serf.Members()
+}
+
+//	Leave tells the other members this node is leaving the cluster
+func (m *Membership) Leave() error {
+	return m.serf.Leave()
+}
+
+//	logError logs errors from the handler. Only the leader can change the
+//		cluster, so raft.ErrNotLeader is expected on every other node and
+//		isn't worth reporting
+func (m *Membership) logError(err error, msg string, member serf.Member) {
+	if err == raft.ErrNotLeader {
+		return
+	}
+	logger := m.Config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Error(msg,
+		"name", member.Name,
+		"rpc_addr", member.Tags["rpc_addr"],
+		"err", err,
+	)
+}
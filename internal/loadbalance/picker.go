@@ -0,0 +1,74 @@
+package loadbalance
+
+import (
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+func init() {
+	balancer.Register(
+		base.NewBalancerBuilder(Name, &Picker{}, base.Config{}),
+	)
+}
+
+//	Picker sends produces to the leader, since only it can append, and spreads
+//		consumes over the followers so reads don't compete with writes. With
+//		no followers consumes go to the leader; anything else goes to the
+//		leader when there is one
+type Picker struct {
+	mu        sync.Mutex
+	leader    balancer.SubConn
+	followers []balancer.SubConn
+	current   uint64
+}
+
+var _ base.PickerBuilder = (*Picker)(nil)
+
+//	Build is called with the ready connections whenever they change, and
+//		returns a new Picker for them
+func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
+	var followers []balancer.SubConn
+	var leader balancer.SubConn
+	for sc, scInfo := range buildInfo.ReadySCs {
+		if isLeader, _ := scInfo.Address.Attributes.Value(isLeaderKey).(bool); isLeader {
+			leader = sc
+			continue
+		}
+		followers = append(followers, sc)
+	}
+	return &Picker{leader: leader, followers: followers}
+}
+
+var _ balancer.Picker = (*Picker)(nil)
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	method := info.FullMethodName[strings.LastIndex(info.FullMethodName, "/")+1:]
+	var result balancer.PickResult
+	switch {
+	case strings.HasPrefix(method, "Produce"):
+		result.SubConn = p.leader
+	case strings.HasPrefix(method, "Consume") && len(p.followers) > 0:
+		result.SubConn = p.nextFollower()
+	case p.leader != nil:
+		result.SubConn = p.leader
+	case len(p.followers) > 0:
+		result.SubConn = p.nextFollower()
+	}
+	if result.SubConn == nil {
+		return result, balancer.ErrNoSubConnAvailable
+	}
+	return result, nil
+}
+
+//	nextFollower hands out the followers round robin. The caller must hold
+//		the lock
+func (p *Picker) nextFollower() balancer.SubConn {
+	sc := p.followers[p.current%uint64(len(p.followers))]
+	p.current++
+	return sc
+}
@@ -0,0 +1,82 @@
+package loadbalance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestPickerNoSubConnAvailable(t *testing.T) {
+	picker := &Picker{}
+	for _, method := range []string{
+		"/log.v1.Log/Produce",
+		"/log.v1.Log/Consume",
+		"/log.v1.Log/GetOffsets",
+	} {
+		_, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+	}
+}
+
+func TestPickerProducesToLeader(t *testing.T) {
+	picker, subConns := setupPicker()
+	for i := 0; i < 5; i++ {
+		for _, method := range []string{"/log.v1.Log/Produce", "/log.v1.Log/ProduceBatch"} {
+			result, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+			require.NoError(t, err)
+			require.Equal(t, subConns[0], result.SubConn)
+		}
+	}
+}
+
+func TestPickerConsumesFromFollowers(t *testing.T) {
+	picker, subConns := setupPicker()
+	seen := make(map[balancer.SubConn]int)
+	for i := 0; i < 4; i++ {
+		result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/Consume"})
+		require.NoError(t, err)
+		require.NotEqual(t, subConns[0], result.SubConn)
+		seen[result.SubConn]++
+	}
+	require.Equal(t, map[balancer.SubConn]int{subConns[1]: 2, subConns[2]: 2}, seen)
+}
+
+func TestPickerConsumesFromLeaderAlone(t *testing.T) {
+	picker, subConns := setupPicker()
+	picker = (&Picker{}).Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			subConns[0]: {Address: address(true)},
+		},
+	}).(*Picker)
+	result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/log.v1.Log/ConsumeStream"})
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], result.SubConn)
+}
+
+//	setupPicker returns a picker over a leader, subConns[0], and two followers
+func setupPicker() (*Picker, []*subConn) {
+	var subConns []*subConn
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for i := 0; i < 3; i++ {
+		sc := &subConn{id: i}
+		subConns = append(subConns, sc)
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: address(i == 0)}
+	}
+	return (&Picker{}).Build(buildInfo).(*Picker), subConns
+}
+
+func address(isLeader bool) resolver.Address {
+	return resolver.Address{Attributes: attributes.New(isLeaderKey, isLeader)}
+}
+
+//	subConn stands in for a connection; only its identity matters
+type subConn struct {
+	balancer.SubConn
+	id int
+}
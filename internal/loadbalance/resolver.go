@@ -0,0 +1,102 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+//	Name is both the resolver's scheme and the balancer's name. Dialing
+//		"hydralog:///host:port" asks the server at host:port for the rest of
+//		the cluster and balances requests over it
+const Name = "hydralog"
+
+//	address attribute marking the raft leader
+const isLeaderKey = "is_leader"
+
+func init() {
+	resolver.Register(builder{})
+}
+
+type builder struct{}
+
+func (builder) Scheme() string {
+	return Name
+}
+
+func (builder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	var dialOpts []grpc.DialOption
+	if opts.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	conn, err := grpc.NewClient(target.Endpoint(), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	r := &Resolver{
+		clientConn:   cc,
+		resolverConn: conn,
+		serviceConfig: cc.ParseServiceConfig(
+			fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, Name),
+		),
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	return r, nil
+}
+
+//	Resolver turns the cluster's GetServers answer into the addresses a
+//		client connection balances over, each tagged with whether it's the
+//		leader. gRPC asks it again whenever a connection fails
+type Resolver struct {
+	mu            sync.Mutex
+	clientConn    resolver.ClientConn
+	resolverConn  *grpc.ClientConn
+	serviceConfig *serviceconfig.ParseResult
+}
+
+var _ resolver.Resolver = (*Resolver)(nil)
+
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client := api.NewLogClient(r.resolverConn)
+	res, err := client.GetServers(context.Background(), &api.GetServersRequest{})
+	if err != nil {
+		log.Printf("loadbalance: failed to resolve servers: %v", err)
+		r.clientConn.ReportError(err)
+		return
+	}
+	var addrs []resolver.Address
+	for _, server := range res.Servers {
+		addrs = append(addrs, resolver.Address{
+			Addr:       server.RpcAddr,
+			Attributes: attributes.New(isLeaderKey, server.IsLeader),
+		})
+	}
+	if err := r.clientConn.UpdateState(resolver.State{
+		Addresses:     addrs,
+		ServiceConfig: r.serviceConfig,
+	}); err != nil {
+		log.Printf("loadbalance: failed to update client connection: %v", err)
+	}
+}
+
+func (r *Resolver) Close() {
+	if err := r.resolverConn.Close(); err != nil {
+		log.Printf("loadbalance: failed to close resolver connection: %v", err)
+	}
+}
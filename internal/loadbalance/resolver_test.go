@@ -0,0 +1,75 @@
+package loadbalance
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func TestResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{
+		ServerGetter: &getServers{},
+	})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	target, err := url.Parse(Name + ":///" + l.Addr().String())
+	require.NoError(t, err)
+	conn := &clientConn{}
+	r, err := builder{}.Build(
+		resolver.Target{URL: *target},
+		conn,
+		resolver.BuildOptions{},
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, []resolver.Address{{
+		Addr:       "localhost:9001",
+		Attributes: attributes.New(isLeaderKey, true),
+	}, {
+		Addr:       "localhost:9002",
+		Attributes: attributes.New(isLeaderKey, false),
+	}}, conn.state.Addresses)
+	require.NotNil(t, conn.state.ServiceConfig)
+}
+
+type getServers struct{}
+
+func (s *getServers) GetServers() ([]*api.Server, error) {
+	return []*api.Server{{
+		Id:       "leader",
+		RpcAddr:  "localhost:9001",
+		IsLeader: true,
+	}, {
+		Id:      "follower",
+		RpcAddr: "localhost:9002",
+	}}, nil
+}
+
+//	clientConn records what the resolver hands to the client connection
+type clientConn struct {
+	resolver.ClientConn
+	state resolver.State
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.state = state
+	return nil
+}
+
+func (c *clientConn) ReportError(err error) {}
+
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
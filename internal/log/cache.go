@@ -0,0 +1,130 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+//	roughly what a cached record costs beyond its encoded size: the entry,
+//		its list element and map slot, and the record's own fields
+const cachedRecordOverhead = 128
+
+//	recordCache holds records that were compressed or encrypted in the store,
+//		already decoded, so consumers reading the same stretch of a segment
+//		don't decode it over and over. It's an LRU bounded by the bytes the
+//		records take; a nil cache caches nothing
+type recordCache struct {
+	mu      sync.Mutex
+	max     uint64
+	size    uint64
+	lru     *list.List
+	entries map[recordKey]*list.Element
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+//	records are cached by where they are, so a segment that's rewritten
+//		starts with nothing cached
+type recordKey struct {
+	s   *segment
+	pos uint64
+}
+
+type cachedRecord struct {
+	key    recordKey
+	record *api.Record
+	//	the length of the record in the store, to step past it
+	n    uint64
+	size uint64
+}
+
+func newRecordCache(max uint64) *recordCache {
+	return &recordCache{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[recordKey]*list.Element),
+	}
+}
+
+//	get returns a copy of the record cached for pos in s, and its length in
+//		the store
+func (c *recordCache) get(s *segment, pos uint64) (*api.Record, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[recordKey{s, pos}]
+	if !ok {
+		c.mu.Unlock()
+		return nil, 0, false
+	}
+	c.lru.MoveToFront(e)
+	entry := e.Value.(*cachedRecord)
+	c.mu.Unlock()
+	c.hits.Add(1)
+	//	callers are free to change what they're given
+	return proto.Clone(entry.record).(*api.Record), entry.n, true
+}
+
+//	put caches record, which is n bytes at pos in s and had to be decoded,
+//		pushing out the least recently read records to make room
+func (c *recordCache) put(s *segment, pos uint64, record *api.Record, n uint64) {
+	if c == nil {
+		return
+	}
+	c.misses.Add(1)
+	size := uint64(proto.Size(record)) + cachedRecordOverhead
+	if size > c.max {
+		return
+	}
+	key := recordKey{s, pos}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cachedRecord{
+		key:    key,
+		record: proto.Clone(record).(*api.Record),
+		n:      n,
+		size:   size,
+	})
+	c.size += size
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+//	purge drops everything cached for s, for when it's closed or cut back
+func (c *recordCache) purge(s *segment) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if key.s == s {
+			c.remove(e)
+		}
+	}
+}
+
+func (c *recordCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cachedRecord)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+//	DecodeCache reports how many reads of compressed or encrypted records
+//		were served from the decode cache, and how many had to decode. The
+//		cache is shared, so it counts the reads of every log sharing it
+func (l *Log) DecodeCache() (hits, misses uint64) {
+	if l.Config.cache == nil {
+		return 0, 0
+	}
+	return l.Config.cache.hits.Load(), l.Config.cache.misses.Load()
+}
@@ -0,0 +1,98 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRecordCache(t *testing.T) {
+	record := &api.Record{Value: []byte("hello world")}
+	size := uint64(proto.Size(record)) + cachedRecordOverhead
+	c := newRecordCache(2 * size)
+	s1, s2 := &segment{}, &segment{}
+
+	c.put(s1, 0, record, 10)
+	c.put(s1, 10, record, 10)
+	got, n, ok := c.get(s1, 0)
+	require.True(t, ok)
+	require.Equal(t, uint64(10), n)
+	require.True(t, proto.Equal(record, got))
+
+	// s1 at 10 is the least recently read, so it makes room
+	c.put(s2, 0, record, 10)
+	_, _, ok = c.get(s1, 10)
+	require.False(t, ok)
+	_, _, ok = c.get(s1, 0)
+	require.True(t, ok)
+
+	c.purge(s1)
+	_, _, ok = c.get(s1, 0)
+	require.False(t, ok)
+	_, _, ok = c.get(s2, 0)
+	require.True(t, ok)
+	require.Equal(t, size, c.size)
+
+	var none *recordCache
+	none.put(s1, 0, record, 10)
+	_, _, ok = none.get(s1, 0)
+	require.False(t, ok)
+}
+
+func TestTopicsShareDecodeCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "decode-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Store.Compression = CompressionZstd
+	c.Store.DecodeCacheBytes = 1 << 20
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+	defer topics.Close()
+
+	a, err := topics.Topic("a")
+	require.NoError(t, err)
+	b, err := topics.Topic("b")
+	require.NoError(t, err)
+	// one budget for every topic, not one each
+	require.NotNil(t, topics.Config.cache)
+	require.Same(t, topics.Config.cache, a.Config.cache)
+	require.Same(t, topics.Config.cache, b.Config.cache)
+}
+
+func TestLogDecodeCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "decode-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Store.Compression = CompressionZstd
+	c.Store.DecodeCacheBytes = 1 << 20
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	value := bytes.Repeat([]byte("hello world "), 50)
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: value})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		for off := uint64(0); off < 3; off++ {
+			record, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, value, record.Value)
+			// what a reader does with its record doesn't reach the cache
+			record.Value = nil
+		}
+	}
+	hits, misses := log.DecodeCache()
+	require.Equal(t, uint64(3), hits)
+	require.Equal(t, uint64(3), misses)
+}
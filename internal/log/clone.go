@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	a clone is put together in a directory of its own under .clone and
+//		renamed to its topic's directory once it's complete, so a crash never
+//		leaves half a topic behind. Anything in here on startup is discarded
+const cloneDir = ".clone"
+
+//	CloneTopic creates topic clone holding a copy of topic's records as they
+//		stand now, and returns the highest offset it got. Sealed stores are
+//		hard linked where the filesystem allows, since they're only ever
+//		replaced rather than changed; the rest is copied. From then on the
+//		two topics go their own ways. The copying is done without the topics'
+//		lock, which is taken only to register the clone
+func (t *Topics) CloneTopic(topic, clone string) (uint64, error) {
+	if clone == EventsTopic || !validTopic.MatchString(clone) {
+		return 0, api.ErrInvalidTopic{Topic: clone}
+	}
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	t.mu.RLock()
+	src, ok := t.logs[topic]
+	_, exists := t.logs[clone]
+	t.mu.RUnlock()
+	if !ok {
+		return 0, api.ErrTopicNotFound{Topic: topic}
+	}
+	if exists {
+		return 0, api.ErrTopicExists{Topic: clone}
+	}
+
+	if err := os.MkdirAll(path.Join(t.Dir, cloneDir), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.MkdirTemp(path.Join(t.Dir, cloneDir), clone+"-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := src.cloneTo(tmp); err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	//	someone may have created the clone, or deleted what it was copied
+	//		from, while it was copied
+	if _, ok := t.logs[clone]; ok {
+		return 0, api.ErrTopicExists{Topic: clone}
+	}
+	if t.logs[topic] != src {
+		return 0, api.ErrTopicNotFound{Topic: topic}
+	}
+	dir := path.Join(t.Dir, clone)
+	if err := os.Rename(tmp, dir); err != nil {
+		return 0, err
+	}
+	l, err := NewLog(dir, t.topicConfig(clone))
+	if err != nil {
+		return 0, err
+	}
+	t.observe(clone, l)
+	t.logs[clone] = l
+	l.Config.Logger.Info("topic cloned", "from", src.Dir)
+	_ = t.Publish(Event{Type: EventTopicCreated, Topic: clone})
+	return l.HighestOffset()
+}
+
+//	cloneTo writes the log's segments into dir as they are now. The clone
+//		has no manifest, so opening it checks it over and rebuilds its time
+//		indexes
+func (l *Log) cloneTo(dir string) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		if err := s.store.Flush(); err != nil {
+			return err
+		}
+		store := path.Join(dir, path.Base(s.store.Name()))
+		//	the active segment is still being appended to
+		if s == l.activeSegment || os.Link(s.store.Name(), store) != nil {
+			if err := copyFile(store, s.store.File, s.store.size); err != nil {
+				return err
+			}
+		}
+		index := path.Join(dir, path.Base(s.index.Name()))
+		if err := os.WriteFile(index, s.index.mmap[:s.index.size], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	copyFile writes the first size bytes of src to a new file name
+func copyFile(name string, src *os.File, size uint64) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, io.NewSectionReader(src, 0, int64(size))); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("log: copy %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneTopic(t *testing.T) {
+	dir, err := os.MkdirTemp("", "clone-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := topics.Append("prod", &api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+
+	highest, err := topics.CloneTopic("prod", "staging")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), highest)
+
+	// sealed stores are shared, the active one is copied
+	src, err := topics.existing("prod")
+	require.NoError(t, err)
+	clone, err := topics.existing("staging")
+	require.NoError(t, err)
+	require.Equal(t, len(src.segments), len(clone.segments))
+	for i, s := range src.segments {
+		a, err := os.Stat(s.store.Name())
+		require.NoError(t, err)
+		b, err := os.Stat(clone.segments[i].store.Name())
+		require.NoError(t, err)
+		require.Equal(t, s != src.activeSegment, os.SameFile(a, b))
+	}
+
+	// after which they're separate
+	_, err = topics.Append("prod", &api.Record{Value: []byte("prod only")})
+	require.NoError(t, err)
+	off, err := topics.Append("staging", &api.Record{Value: []byte("staging only")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	record, err := topics.Read("prod", 5)
+	require.NoError(t, err)
+	require.Equal(t, "prod only", string(record.Value))
+
+	_, err = topics.CloneTopic("prod", "staging")
+	require.Equal(t, api.ErrTopicExists{Topic: "staging"}, err)
+	_, err = topics.CloneTopic("missing", "other")
+	require.Equal(t, api.ErrTopicNotFound{Topic: "missing"}, err)
+	_, err = topics.CloneTopic("prod", ".trash")
+	require.Equal(t, api.ErrInvalidTopic{Topic: ".trash"}, err)
+	require.NoError(t, topics.Close())
+
+	// a clone cut short is thrown away; a finished one opens like any topic
+	require.NoError(t, os.MkdirAll(path.Join(dir, cloneDir), 0755))
+	topics, err = NewTopics(dir, c)
+	require.NoError(t, err)
+	defer topics.Close()
+	_, err = os.Stat(path.Join(dir, cloneDir))
+	require.True(t, os.IsNotExist(err))
+	for off := uint64(0); off < 5; off++ {
+		record, err := topics.Read("staging", off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+	}
+	record, err = topics.Read("staging", 5)
+	require.NoError(t, err)
+	require.Equal(t, "staging only", string(record.Value))
+}
+
+func TestCloneTopicUnlocked(t *testing.T) {
+	dir, err := os.MkdirTemp("", "clone-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topics, err := NewTopics(dir, Config{})
+	require.NoError(t, err)
+	defer topics.Close()
+	_, err = topics.Append("prod", &api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// hold the clone up while it copies, and create a topic meanwhile
+	src, err := topics.existing("prod")
+	require.NoError(t, err)
+	src.mu.Lock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := topics.CloneTopic("prod", "staging")
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path.Join(dir, cloneDir))
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+	created := make(chan error, 1)
+	go func() {
+		_, err := topics.Topic("other")
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		src.mu.Unlock()
+		t.Fatal("creating a topic waited for the clone")
+	}
+	src.mu.Unlock()
+	require.NoError(t, <-done)
+
+	// the staging directory is gone once the clone is in place
+	files, err := os.ReadDir(path.Join(dir, cloneDir))
+	require.NoError(t, err)
+	require.Empty(t, files)
+	record, err := topics.Read("staging", 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(record.Value))
+}
@@ -0,0 +1,270 @@
+package log
+
+import (
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	compacted segments are built in .compact/<base offset>, and each is
+//		renamed to .compact/ready as it's swapped in. Files left in ready after
+//		a crash are moved into place on startup; anything else in here is
+//		discarded
+const compactDir = ".compact"
+
+//	enforceCompaction compacts the log every interval until it's closed
+func (l *Log) enforceCompaction(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := l.Compact(); err != nil {
+				l.Config.Logger.Error("compaction failed", "err", err)
+			}
+		}
+	}
+}
+
+//	Compact rewrites the sealed segments so that only the newest record for
+//		each key (as Compaction.Key sees it) is left in them. Records without
+//		a key are always kept and offsets never change; reads of an offset
+//		that's gone get the next record instead. Segments an observer won't let go of are left as they
+//		are, and a segment with nothing left in it is removed. The rewrites
+//		are built under the read lock, so only appends wait for them; the
+//		write lock is held just to swap them in
+func (l *Log) Compact() error {
+	l.compacting.Lock()
+	defer l.compacting.Unlock()
+	dir := path.Join(l.Dir, compactDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	compactions, err := l.buildCompactions()
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if len(compactions) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	//	closed while the rewrites were built
+	if l.done == nil {
+		return os.RemoveAll(dir)
+	}
+	//	the rewritten segment for each original, nil for one to remove
+	replaced := make(map[*segment]*segment)
+	for _, c := range compactions {
+		//	retention or a truncate may have removed it in the meantime,
+		//		or an observer have taken hold of it
+		if !slices.Contains(l.segments, c.s) || !l.allowDelete(c.s) {
+			continue
+		}
+		if c.dir == "" {
+			if err := l.removeSegment(c.s); err != nil {
+				return err
+			}
+			replaced[c.s] = nil
+			continue
+		}
+		r, err := l.swapCompacted(c)
+		if err != nil {
+			return err
+		}
+		replaced[c.s] = r
+	}
+	var segments []*segment
+	for _, s := range l.segments {
+		if r, ok := replaced[s]; ok {
+			if r == nil {
+				continue
+			}
+			s = r
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return writeManifest(l.Dir, l.manifest(false))
+}
+
+//	compaction is a sealed segment's rewrite, built and waiting to replace it
+type compaction struct {
+	s *segment
+	//	where the rewritten segment was built; empty when nothing in s is
+	//		current and it's to be removed
+	dir string
+}
+
+//	buildCompactions finds the newest record for each key and rewrites the
+//		sealed segments that have older ones, under the read lock
+func (l *Log) buildCompactions() ([]compaction, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	latest := make(map[string]uint64)
+	for _, s := range l.segments {
+		if err := s.scan(func(record *api.Record, _ []byte) {
+			if key := l.compactionKey(record); len(key) > 0 {
+				latest[string(key)] = record.Offset
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	var compactions []compaction
+	for _, s := range l.segments {
+		if s == l.activeSegment || !l.allowDelete(s) {
+			continue
+		}
+		c, ok, err := l.compactSegment(s, latest)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			compactions = append(compactions, c)
+		}
+	}
+	return compactions, nil
+}
+
+//	compactSegment builds s without the records latest has a newer offset
+//		for. ok is false if there are none, and the compaction has no dir if
+//		nothing in s is current. The caller must hold the read lock
+func (l *Log) compactSegment(s *segment, latest map[string]uint64) (c compaction, ok bool, err error) {
+	var (
+		offsets    []uint64
+		timestamps []int64
+		kept       [][]byte
+		dropped    int
+	)
+	if err := s.scan(func(record *api.Record, p []byte) {
+		if key := l.compactionKey(record); len(key) > 0 && latest[string(key)] != record.Offset {
+			dropped++
+			return
+		}
+		offsets = append(offsets, record.Offset)
+		timestamps = append(timestamps, record.Timestamp)
+		kept = append(kept, p)
+	}); err != nil {
+		return c, false, err
+	}
+	if dropped == 0 {
+		return c, false, nil
+	}
+	c.s = s
+	if len(kept) == 0 {
+		return c, true, nil
+	}
+
+	dir := path.Join(l.Dir, compactDir, strconv.FormatUint(s.baseOffset, 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return c, false, err
+	}
+	n, err := newSegment(dir, s.baseOffset, l.Config)
+	if err != nil {
+		return c, false, err
+	}
+	//	the records are copied as they are, so they keep their offsets
+	positions, err := n.store.AppendBatch(kept)
+	if err != nil {
+		return c, false, err
+	}
+	for i, pos := range positions {
+		if err := n.indexRecord(offsets[i], pos); err != nil {
+			return c, false, err
+		}
+		if err := n.indexTime(offsets[i], pos, timestamps[i]); err != nil {
+			return c, false, err
+		}
+	}
+	if err := n.store.Sync(); err != nil {
+		return c, false, err
+	}
+	if err := n.Close(); err != nil {
+		return c, false, err
+	}
+	c.dir = dir
+	return c, true, nil
+}
+
+//	swapCompacted moves a built rewrite over its segment and opens it in the
+//		original's place. The caller must hold the write lock
+func (l *Log) swapCompacted(c compaction) (*segment, error) {
+	ready := path.Join(l.Dir, compactDir, "ready")
+	if err := os.Rename(c.dir, ready); err != nil {
+		return nil, err
+	}
+	if err := c.s.Close(); err != nil {
+		return nil, err
+	}
+	if err := moveReady(l.Dir); err != nil {
+		return nil, err
+	}
+	r, err := newSegment(l.Dir, c.s.baseOffset, l.Config)
+	if err != nil {
+		return nil, err
+	}
+	//	the segment still covers the offsets that were at its end
+	r.nextOffset = c.s.nextOffset
+	return r, nil
+}
+
+//	finishCompaction moves a completed compacted segment over the original and
+//		clears out the compaction directory. Moving a file twice is harmless,
+//		so a crash part way through is finished off by the next startup
+func finishCompaction(dir string) error {
+	if err := moveReady(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(dir, compactDir))
+}
+
+//	moveReady moves the files of the compacted segment in .compact/ready over
+//		the original's
+func moveReady(dir string) error {
+	ready := path.Join(dir, compactDir, "ready")
+	files, err := os.ReadDir(ready)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Rename(
+			path.Join(ready, file.Name()),
+			path.Join(dir, file.Name()),
+		); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(ready)
+}
+
+//	scan calls fn with every record in the segment and its encoded form. It
+//		reads the store straight through, since with sparse indexing not every
+//		record is in the index
+func (s *segment) scan(fn func(record *api.Record, p []byte)) error {
+	for pos := uint64(0); pos < s.store.size; {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return err
+		}
+		record, err := s.decode(p)
+		if err != nil {
+			return err
+		}
+		fn(record, p)
+		pos += headerWidth + uint64(len(p))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+)
+
+//	Compression is the codec records are compressed with before they're
+//		written to the store
+type Compression int
+
+const (
+	//	records are stored as they are
+	CompressionNone Compression = iota
+	CompressionGzip
+	//	fast, for a modest saving
+	CompressionSnappy
+	//	usually the best ratio for the CPU it takes
+	CompressionZstd
+)
+
+//	ParseCompression reads a codec from its name: none (or empty), gzip,
+//		snappy or zstd
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("log: unknown compression %q", name)
+}
+
+//	a compressed entry starts with a zero byte, which a marshalled record
+//		never does (there's no field 0), then the codec. Entries written
+//		before compression existed, or that didn't get any smaller, are
+//		plain records
+const compressedMarker = 0
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+//	encodeRecord marshals record and compresses it with c, if that makes it
+//		smaller
+func encodeRecord(record *api.Record, c Compression) ([]byte, error) {
+	p, err := proto.Marshal(record)
+	if err != nil || c == CompressionNone {
+		return p, err
+	}
+	var compressed []byte
+	switch c {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		compressed = buf.Bytes()
+	case CompressionSnappy:
+		compressed = snappy.Encode(nil, p)
+	case CompressionZstd:
+		compressed = zstdEncoder.EncodeAll(p, nil)
+	default:
+		return nil, fmt.Errorf("log: unknown compression %d", c)
+	}
+	if len(compressed)+2 >= len(p) {
+		return p, nil
+	}
+	return append([]byte{compressedMarker, byte(c)}, compressed...), nil
+}
+
+//	encoded reports whether p was compressed (or encrypted) rather than
+//		stored as a plain record
+func encoded(p []byte) bool {
+	return len(p) >= 2 && p[0] == compressedMarker
+}
+
+//	decodeRecord is the inverse of encodeRecord
+func decodeRecord(p []byte) (*api.Record, error) {
+	if encoded(p) {
+		var err error
+		switch Compression(p[1]) {
+		case CompressionGzip:
+			var r *gzip.Reader
+			if r, err = gzip.NewReader(bytes.NewReader(p[2:])); err == nil {
+				p, err = io.ReadAll(r)
+			}
+		case CompressionSnappy:
+			p, err = snappy.Decode(nil, p[2:])
+		case CompressionZstd:
+			p, err = zstdDecoder.DecodeAll(p[2:], nil)
+		default:
+			err = fmt.Errorf("log: unknown compression %d", p[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	record := &api.Record{}
+	if err := proto.Unmarshal(p, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
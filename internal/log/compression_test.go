@@ -0,0 +1,74 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCompression(t *testing.T) {
+	record := &api.Record{
+		Value:  bytes.Repeat([]byte(`{"level":"info","msg":"hello world"}`), 20),
+		Offset: 7,
+	}
+	plain, err := proto.Marshal(record)
+	require.NoError(t, err)
+
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy, CompressionZstd} {
+		p, err := encodeRecord(record, c)
+		require.NoError(t, err)
+		if c == CompressionNone {
+			require.Equal(t, plain, p)
+		} else {
+			require.Less(t, len(p), len(plain))
+		}
+		got, err := decodeRecord(p)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(record, got))
+	}
+
+	// records that don't get smaller are stored as they are
+	small := &api.Record{Value: []byte("hi")}
+	p, err := encodeRecord(small, CompressionZstd)
+	require.NoError(t, err)
+	plain, err = proto.Marshal(small)
+	require.NoError(t, err)
+	require.Equal(t, plain, p)
+
+	_, err = decodeRecord([]byte{compressedMarker, 99, 1, 2, 3})
+	require.Error(t, err)
+}
+
+func TestLogCompression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "compression-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	value := bytes.Repeat([]byte("hello world "), 50)
+	_, err = log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// records written before compression was turned on still read
+	c.Store.Compression = CompressionSnappy
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	_, err = log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	seg := log.activeSegment
+	require.Less(t, seg.store.size, 2*(uint64(len(value))+headerWidth))
+	for off := uint64(0); off < 2; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, value, record.Value)
+	}
+}
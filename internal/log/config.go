@@ -1,9 +1,135 @@
 package log
 
+import (
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+//	SyncMode decides when appended records are fsynced to disk
+type SyncMode int
+
+const (
+	//	records sit in the write buffer until a read, a roll or Close
+	SyncNone SyncMode = iota
+	//	every append is flushed and fsynced before it returns
+	SyncAlways
+	//	every N appends are flushed and fsynced together
+	SyncEveryNWrites
+	//	the active segment is flushed and fsynced on a timer
+	SyncInterval
+)
+
+type SyncPolicy struct {
+	Mode SyncMode
+	//	used by SyncEveryNWrites
+	N uint64
+	//	used by SyncInterval
+	Interval time.Duration
+}
+
 type Config struct {
+	//	where segment changes, recovery, compaction and background errors are
+	//		reported; nil reports nothing. Topics adds a topic attribute
+	Logger *slog.Logger
+	Store struct {
+		SyncPolicy SyncPolicy
+		//	codec new records are compressed with; records already written
+		//		are read whatever they were written with
+		Compression Compression
+		//	with Keys set, new records are encrypted with AES-GCM under its
+		//		current key (after being compressed); nil leaves them in the
+		//		clear. Records it has no key for can't be read
+		Encryption struct {
+			Keys KeyProvider
+		}
+		//	after every fsync, copy the store's last partial block of this
+		//		many bytes to a scratch file, and put it back on open if the
+		//		store's copy doesn't match. For disks that can tear a block
+		//		that's rewritten, losing records that were already synced;
+		//		usually the filesystem's block size. 0 turns it off
+		DoubleWriteBlockBytes uint64
+		//	bytes of compressed or encrypted records kept decoded for reads
+		//		that come back to them; 0 turns the cache off. It's the budget
+		//		for the whole of Topics or DistributedLog, shared by every
+		//		topic (and raft's log), not for each one
+		DecodeCacheBytes uint64
+	}
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		//	how often dirty index pages are handed to the kernel with
+		//		MS_ASYNC; 0 leaves it to Close
+		IndexSyncInterval time.Duration
+		//	number of unsynced index entries that forces an MS_SYNC; 0 means
+		//		no limit
+		IndexMaxDirtyEntries uint64
+		//	index a record only once this many bytes of store have been
+		//		written since the last indexed one; reads scan forward from
+		//		the nearest entry. 0 indexes every record
+		IndexIntervalBytes uint64
+		//	number of the newest segments whose index and store are read
+		//		through in the background on startup; 0 disables warming
+		WarmSegments int
+	}
+	Retention struct {
+		//	sealed segments whose newest record is older than this are
+		//		removed; 0 keeps data forever
+		MaxAge time.Duration
+		//	the oldest sealed segments are removed while the stores add up
+		//		to more than this; 0 means no limit
+		MaxLogBytes uint64
+		//	how often the retention policy runs; defaults to a minute
+		CheckInterval time.Duration
+	}
+	Compaction struct {
+		//	how often sealed segments are compacted down to the newest
+		//		record per key; 0 only compacts when Compact is called
+		Interval time.Duration
+		//	how a record's key is derived for compaction; nil uses the
+		//		record's own key
+		Key KeyExtractor
+		//	extractors for particular topics, overriding Key for them
+		TopicKeys map[string]KeyExtractor
+	}
+	ShadowRead struct {
+		//	fraction of reads, from 0 to 1, that are checked against a scan
+		//		of the store that doesn't use the index; 0 turns it off
+		SampleRate float64
+	}
+	Trash struct {
+		//	how long truncated segments are kept in the .trash directory
+		//		before they're deleted; 0 deletes them straight away
+		GracePeriod time.Duration
+	}
+	//	used by Topics and DistributedLog
+	Events struct {
+		//	publish topic and segment changes to EventsTopic, and for a
+		//		DistributedLog leader changes and servers joining and leaving
+		Enabled bool
+	}
+	//	used by DistributedLog
+	Raft struct {
+		raft.Config
+		StreamLayer *StreamLayer
+		//	start a new cluster with this node as its only member
+		Bootstrap bool
+		//	compress the raft traffic this node sends with zstd. Peers
+		//		answer in kind, and take either
+		Compress bool
+		//	most bytes a second sent to each peer, so catching a replica up
+		//		doesn't fill the link; 0 is no limit
+		PeerBytesPerSecond uint64
+	}
+	//	the decode cache, made from Store.DecodeCacheBytes by whichever of
+	//		DistributedLog, Topics and NewLog gets the config first, so the
+	//		logs under it share the one budget
+	cache *recordCache
+	Manifest struct {
+		//	how often the manifest is rewritten to checkpoint the high
+		//		watermark; 0 only writes it when segments change
+		Interval time.Duration
 	}
 }
\ No newline at end of file
@@ -0,0 +1,847 @@
+package log
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+//	DistributedLog replicates Topics across a cluster with Raft. Appends go
+//		through the leader and are applied on every node; reads are served
+//		from the local copy, so any node can answer them
+type DistributedLog struct {
+	config Config
+	topics *Topics
+	raft   *raft.Raft
+	//	raft doesn't close its stores on shutdown
+	logStore    *logStore
+	stableStore *raftboltdb.BoltStore
+	//	watches for new leaders when events are enabled; observed is closed
+	//		once the last of them has been published
+	observer     *raft.Observer
+	observations chan raft.Observation
+	observed     chan struct{}
+}
+
+func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
+	l := &DistributedLog{config: config}
+	if err := l.setup(dataDir); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *DistributedLog) setup(dataDir string) error {
+	raftDir := path.Join(dataDir, "raft")
+	if err := os.MkdirAll(path.Join(raftDir, "log"), 0755); err != nil {
+		return err
+	}
+	//	raft's own log starts at index 1 and gets none of the topic policies
+	if l.config.Store.DecodeCacheBytes > 0 {
+		l.config.cache = newRecordCache(l.config.Store.DecodeCacheBytes)
+	}
+	logConfig := Config{}
+	logConfig.cache = l.config.cache
+	logConfig.Store = l.config.Store
+	logConfig.Segment = l.config.Segment
+	logConfig.Segment.InitialOffset = 1
+	if l.config.Logger != nil {
+		logConfig.Logger = l.config.Logger.With("log", "raft")
+	}
+	var err error
+	if l.logStore, err = newLogStore(path.Join(raftDir, "log"), logConfig); err != nil {
+		return err
+	}
+	if l.stableStore, err = raftboltdb.NewBoltStore(path.Join(raftDir, "stable")); err != nil {
+		return err
+	}
+	retain := 1
+	snapshotStore, err := raft.NewFileSnapshotStore(raftDir, retain, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	//	raft restores the latest snapshot and replays everything after it on
+	//		startup, so the topics have to start out empty or records would be
+	//		applied twice
+	topicsDir := path.Join(dataDir, "topics")
+	hasState, err := raft.HasExistingState(l.logStore, l.stableStore, snapshotStore)
+	if err != nil {
+		return err
+	}
+	if hasState {
+		if err := os.RemoveAll(topicsDir); err != nil {
+			return err
+		}
+	}
+	if l.topics, err = NewTopics(topicsDir, l.config); err != nil {
+		return err
+	}
+
+	l.config.Raft.StreamLayer.compress = l.config.Raft.Compress
+	l.config.Raft.StreamLayer.peerBytesPerSecond = l.config.Raft.PeerBytesPerSecond
+	maxPool := 5
+	timeout := 10 * time.Second
+	transport := raft.NewNetworkTransport(
+		l.config.Raft.StreamLayer,
+		maxPool,
+		timeout,
+		os.Stderr,
+	)
+
+	config := raft.DefaultConfig()
+	config.LocalID = l.config.Raft.LocalID
+	if l.config.Raft.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
+	}
+	if l.config.Raft.ElectionTimeout != 0 {
+		config.ElectionTimeout = l.config.Raft.ElectionTimeout
+	}
+	if l.config.Raft.LeaderLeaseTimeout != 0 {
+		config.LeaderLeaseTimeout = l.config.Raft.LeaderLeaseTimeout
+	}
+	if l.config.Raft.CommitTimeout != 0 {
+		config.CommitTimeout = l.config.Raft.CommitTimeout
+	}
+
+	if l.raft, err = raft.NewRaft(
+		config,
+		&fsm{topics: l.topics},
+		l.logStore,
+		l.stableStore,
+		snapshotStore,
+		transport,
+	); err != nil {
+		return err
+	}
+	l.observeLeader()
+	if l.config.Raft.Bootstrap && !hasState {
+		config := raft.Configuration{
+			Servers: []raft.Server{{
+				ID:      config.LocalID,
+				Address: transport.LocalAddr(),
+			}},
+		}
+		return l.raft.BootstrapCluster(config).Error()
+	}
+	return nil
+}
+
+func (l *DistributedLog) Append(topic string, record *api.Record) (uint64, error) {
+	//	stamp the record here so every node stores the same time
+	if record.Timestamp == 0 {
+		record.Timestamp = time.Now().UnixNano()
+	}
+	res, err := l.apply(appendRequestType, &api.ProduceRequest{
+		Topic:  topic,
+		Record: record,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(uint64), nil
+}
+
+func (l *DistributedLog) AppendBatch(topic string, records []*api.Record) ([]uint64, error) {
+	now := time.Now().UnixNano()
+	for _, record := range records {
+		if record.Timestamp == 0 {
+			record.Timestamp = now
+		}
+	}
+	res, err := l.apply(appendBatchRequestType, &api.ProduceBatchRequest{
+		Topic:   topic,
+		Records: records,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.([]uint64), nil
+}
+
+//	CloneTopic copies topic into a new topic clone on every node. It goes
+//		through raft so each node clones at the same point in the log
+func (l *DistributedLog) CloneTopic(topic, clone string) (uint64, error) {
+	res, err := l.apply(cloneTopicRequestType, &api.CloneTopicRequest{
+		Topic: topic,
+		Clone: clone,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(uint64), nil
+}
+
+//	apply replicates a request and returns what the FSM made of it. Only the
+//		leader can apply; anywhere else it fails with raft.ErrNotLeader
+func (l *DistributedLog) apply(reqType requestType, req proto.Message) (interface{}, error) {
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	timeout := 10 * time.Second
+	future := l.raft.Apply(append([]byte{byte(reqType)}, b...), timeout)
+	if future.Error() != nil {
+		return nil, future.Error()
+	}
+	res := future.Response()
+	if err, ok := res.(error); ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+//	Read reads from this node's copy, which on a follower may lag behind the
+//		leader
+func (l *DistributedLog) Read(topic string, offset uint64) (*api.Record, error) {
+	return l.topics.Read(topic, offset)
+}
+
+func (l *DistributedLog) Offsets(topic string) (lowest, highest uint64, err error) {
+	return l.topics.Offsets(topic)
+}
+
+func (l *DistributedLog) NextOffset(topic string) (uint64, error) {
+	return l.topics.NextOffset(topic)
+}
+
+func (l *DistributedLog) OffsetForTime(topic string, timestamp int64) (uint64, error) {
+	return l.topics.OffsetForTime(topic, timestamp)
+}
+
+//	Segments describes this node's copy of topic's segments
+func (l *DistributedLog) Segments(topic string) ([]SegmentInfo, error) {
+	return l.topics.Segments(topic)
+}
+
+//	Truncate removes topic's oldest segments up to lowest on every node.
+//		Nodes roll their segments at their own points, so each may keep a
+//		little more or less than the leader does; the lowest offset returned
+//		is the leader's
+func (l *DistributedLog) Truncate(topic string, lowest uint64) (uint64, error) {
+	res, err := l.apply(truncateRequestType, &api.TruncateTopicRequest{
+		Topic:  topic,
+		Lowest: lowest,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(uint64), nil
+}
+
+//	Size returns the bytes the replicated topics hold
+func (l *DistributedLog) Size() uint64 {
+	return l.topics.Size()
+}
+
+//	observeLeader publishes an event each time this node sees the cluster get
+//		a new leader. Like the topics' own events they're local to the node
+func (l *DistributedLog) observeLeader() {
+	if !l.config.Events.Enabled {
+		return
+	}
+	l.observations = make(chan raft.Observation, 16)
+	l.observer = raft.NewObserver(l.observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	l.observed = make(chan struct{})
+	l.raft.RegisterObserver(l.observer)
+	go func() {
+		defer close(l.observed)
+		for o := range l.observations {
+			leader := o.Data.(raft.LeaderObservation)
+			//	an election is under way; the winner gets its own event
+			if leader.LeaderID == "" {
+				continue
+			}
+			_ = l.topics.Publish(Event{
+				Type: EventLeaderChanged,
+				Attributes: map[string]string{
+					"node":        string(l.config.Raft.LocalID),
+					"leader_id":   string(leader.LeaderID),
+					"leader_addr": string(leader.LeaderAddr),
+				},
+			})
+		}
+	}()
+}
+
+//	publishNode publishes that a server joined or left, as membership told
+//		this node
+func (l *DistributedLog) publishNode(typ EventType, id, addr string) {
+	attributes := map[string]string{
+		"node":    string(l.config.Raft.LocalID),
+		"node_id": id,
+	}
+	if addr != "" {
+		attributes["rpc_addr"] = addr
+	}
+	_ = l.topics.Publish(Event{Type: typ, Attributes: attributes})
+}
+
+//	Join adds a voter to the cluster. It's a no-op for a server that's
+//		already a member with that id and address. Membership calls it on
+//		every node, so each publishes the join whether or not it's the leader
+func (l *DistributedLog) Join(id, addr string) error {
+	l.publishNode(EventNodeJoined, id, addr)
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	serverID := raft.ServerID(id)
+	serverAddr := raft.ServerAddress(addr)
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == serverID || srv.Address == serverAddr {
+			if srv.ID == serverID && srv.Address == serverAddr {
+				return nil
+			}
+			//	the id or address is taken by a stale server; remove it
+			if err := l.raft.RemoveServer(srv.ID, 0, 0).Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return l.raft.AddVoter(serverID, serverAddr, 0, 0).Error()
+}
+
+//	Leave removes a server from the cluster
+func (l *DistributedLog) Leave(id string) error {
+	l.publishNode(EventNodeLeft, id, "")
+	return l.raft.RemoveServer(raft.ServerID(id), 0, 0).Error()
+}
+
+//	GetServers returns the members of the cluster and which one leads it.
+//		Raft shares each node's port with its gRPC server (see RaftRPC), so a
+//		server's raft address is also its RPC address
+func (l *DistributedLog) GetServers() ([]*api.Server, error) {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	_, leaderID := l.raft.LeaderWithID()
+	var servers []*api.Server
+	for _, srv := range future.Configuration().Servers {
+		servers = append(servers, &api.Server{
+			Id:       string(srv.ID),
+			RpcAddr:  string(srv.Address),
+			IsLeader: srv.ID == leaderID,
+		})
+	}
+	return servers, nil
+}
+
+//	VerifyRead returns once this node's copy of the topics reflects every
+//		write acknowledged before it was called, making the reads after it
+//		linearizable. That's raft's read index: a quorum confirms this node is
+//		still the leader, and the reply waits for the entries it had logged to
+//		be applied. Only the leader can do this; anywhere else it fails with
+//		api.ErrNotLeader
+func (l *DistributedLog) VerifyRead() error {
+	if l.raft.State() != raft.Leader {
+		leader, _ := l.raft.LeaderWithID()
+		return api.ErrNotLeader{Leader: string(leader)}
+	}
+	index := l.raft.LastIndex()
+	if err := l.raft.VerifyLeader().Error(); err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		leader, _ := l.raft.LeaderWithID()
+		return api.ErrNotLeader{Leader: string(leader)}
+	} else if err != nil {
+		return err
+	}
+	if l.raft.AppliedIndex() >= index {
+		return nil
+	}
+	timeoutc := time.After(10 * time.Second)
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for l.raft.AppliedIndex() < index {
+		select {
+		case <-timeoutc:
+			return fmt.Errorf("log: timed out waiting to apply index %d", index)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+//	WaitForLeader blocks until the cluster has a leader or timeout passes
+func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
+	timeoutc := time.After(timeout)
+	ticker := time.NewTicker(time.Second / 20)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timeoutc:
+			return fmt.Errorf("log: timed out waiting for a leader")
+		case <-ticker.C:
+			if addr, _ := l.raft.LeaderWithID(); addr != "" {
+				return nil
+			}
+		}
+	}
+}
+
+func (l *DistributedLog) Close() error {
+	if l.observer != nil {
+		//	raft doesn't send to an observer once it's deregistered
+		l.raft.DeregisterObserver(l.observer)
+		close(l.observations)
+		<-l.observed
+		l.observer = nil
+	}
+	if err := l.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	if err := l.stableStore.Close(); err != nil {
+		return err
+	}
+	if err := l.logStore.Close(); err != nil {
+		return err
+	}
+	return l.topics.Close()
+}
+
+//	the first byte of every raft entry says what the rest of it is
+type requestType uint8
+
+const (
+	appendRequestType      requestType = 0
+	appendBatchRequestType requestType = 1
+	cloneTopicRequestType  requestType = 2
+	truncateRequestType    requestType = 3
+)
+
+var _ raft.FSM = (*fsm)(nil)
+
+//	fsm applies committed entries to the topics
+type fsm struct {
+	topics *Topics
+}
+
+func (f *fsm) Apply(record *raft.Log) interface{} {
+	if len(record.Data) == 0 {
+		return nil
+	}
+	switch requestType(record.Data[0]) {
+	case appendRequestType:
+		var req api.ProduceRequest
+		if err := proto.Unmarshal(record.Data[1:], &req); err != nil {
+			return err
+		}
+		offset, err := f.topics.Append(req.Topic, req.Record)
+		if err != nil {
+			return err
+		}
+		return offset
+	case appendBatchRequestType:
+		var req api.ProduceBatchRequest
+		if err := proto.Unmarshal(record.Data[1:], &req); err != nil {
+			return err
+		}
+		offsets, err := f.topics.AppendBatch(req.Topic, req.Records)
+		if err != nil {
+			return err
+		}
+		return offsets
+	case cloneTopicRequestType:
+		var req api.CloneTopicRequest
+		if err := proto.Unmarshal(record.Data[1:], &req); err != nil {
+			return err
+		}
+		highest, err := f.topics.CloneTopic(req.Topic, req.Clone)
+		if err != nil {
+			return err
+		}
+		return highest
+	case truncateRequestType:
+		var req api.TruncateTopicRequest
+		if err := proto.Unmarshal(record.Data[1:], &req); err != nil {
+			return err
+		}
+		lowest, err := f.topics.Truncate(req.Topic, req.Lowest)
+		if err != nil {
+			return err
+		}
+		return lowest
+	}
+	return nil
+}
+
+//	Snapshot notes where every segment ends now; Persist then copies that
+//		much of each one while appends carry on
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.topics.mu.RLock()
+	defer f.topics.mu.RUnlock()
+	snap := &snapshot{}
+	for name, l := range f.topics.logs {
+		//	events are about this node, not the cluster
+		if name == EventsTopic {
+			continue
+		}
+		segments, err := l.snapshotSegments(name)
+		if err != nil {
+			snap.Release()
+			return nil, err
+		}
+		snap.segments = append(snap.segments, segments...)
+	}
+	return snap, nil
+}
+
+//	Restore replaces every topic with the ones in the snapshot. Their segment
+//		files are written out as they were and then opened like any others
+func (f *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	for _, name := range f.topics.Names() {
+		if name == EventsTopic {
+			continue
+		}
+		if err := f.topics.Delete(name); err != nil {
+			return err
+		}
+	}
+
+	var topic string
+	open := func() error {
+		if topic == "" {
+			return nil
+		}
+		_, err := f.topics.Topic(topic)
+		return err
+	}
+	for {
+		var seg snapshotSegment
+		err := seg.read(r, f.topics.Dir)
+		if err == io.EOF {
+			return open()
+		}
+		if err != nil {
+			return err
+		}
+		if seg.topic != topic {
+			if err := open(); err != nil {
+				return err
+			}
+			topic = seg.topic
+		}
+	}
+}
+
+//	snapshotSegment is how much of a segment a snapshot holds. In the
+//		snapshot each one is the topic name, the base offset, and then the
+//		store and index, each as a length and that many bytes
+type snapshotSegment struct {
+	topic                string
+	baseOffset           uint64
+	store, index         *os.File
+	storeSize, indexSize uint64
+}
+
+type snapshot struct {
+	segments []snapshotSegment
+}
+
+var _ raft.FSMSnapshot = (*snapshot)(nil)
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	for _, seg := range s.segments {
+		if err := seg.write(sink); err != nil {
+			_ = sink.Cancel()
+			return err
+		}
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) Release() {
+	for _, seg := range s.segments {
+		seg.store.Close()
+		seg.index.Close()
+	}
+}
+
+func (seg snapshotSegment) write(w io.Writer) error {
+	header := make([]byte, 2*lenWidth)
+	enc.PutUint64(header[:lenWidth], uint64(len(seg.topic)))
+	enc.PutUint64(header[lenWidth:], seg.baseOffset)
+	if _, err := w.Write(append(header, seg.topic...)); err != nil {
+		return err
+	}
+	size := make([]byte, lenWidth)
+	for _, file := range []struct {
+		f    *os.File
+		size uint64
+	}{{seg.store, seg.storeSize}, {seg.index, seg.indexSize}} {
+		enc.PutUint64(size, file.size)
+		if _, err := w.Write(size); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(file.f, 0, int64(file.size))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	read reads the next segment from a snapshot and writes its files into
+//		the topic's directory under dir
+func (seg *snapshotSegment) read(r io.Reader, dir string) error {
+	if err := seg.readHeader(r); err != nil {
+		return err
+	}
+	if !validTopic.MatchString(seg.topic) {
+		return api.ErrInvalidTopic{Topic: seg.topic}
+	}
+	return seg.readFiles(r, path.Join(dir, seg.topic))
+}
+
+func (seg *snapshotSegment) readHeader(r io.Reader) error {
+	header := make([]byte, 2*lenWidth)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	name := make([]byte, enc.Uint64(header[:lenWidth]))
+	if _, err := io.ReadFull(r, name); err != nil {
+		return err
+	}
+	seg.topic = string(name)
+	seg.baseOffset = enc.Uint64(header[lenWidth:])
+	return nil
+}
+
+//	readFiles writes the segment's store and index into dir
+func (seg *snapshotSegment) readFiles(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, ext := range []string{".store", ".index"} {
+		size := make([]byte, lenWidth)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return err
+		}
+		f, err := os.Create(path.Join(dir, fmt.Sprintf("%d%s", seg.baseOffset, ext)))
+		if err != nil {
+			return err
+		}
+		if _, err = io.CopyN(f, r, int64(enc.Uint64(size))); err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ raft.LogStore = (*logStore)(nil)
+
+//	logStore keeps raft's log in a Log, one record per entry
+type logStore struct {
+	*Log
+}
+
+func newLogStore(dir string, c Config) (*logStore, error) {
+	log, err := NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{log}, nil
+}
+
+func (l *logStore) FirstIndex() (uint64, error) {
+	return l.LowestOffset()
+}
+
+func (l *logStore) LastIndex() (uint64, error) {
+	return l.HighestOffset()
+}
+
+func (l *logStore) GetLog(index uint64, out *raft.Log) error {
+	in, err := l.Read(index)
+	if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	out.Data = in.Value
+	out.Index = in.Offset
+	out.Type = raft.LogType(in.Type)
+	out.Term = in.Term
+	return nil
+}
+
+func (l *logStore) StoreLog(record *raft.Log) error {
+	return l.StoreLogs([]*raft.Log{record})
+}
+
+func (l *logStore) StoreLogs(records []*raft.Log) error {
+	for _, record := range records {
+		if _, err := l.Append(&api.Record{
+			Value: record.Data,
+			Term:  record.Term,
+			Type:  uint32(record.Type),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	DeleteRange drops either old entries that a snapshot now covers, or
+//		entries at the end that conflict with a new leader's log
+func (l *logStore) DeleteRange(min, max uint64) error {
+	first, err := l.FirstIndex()
+	if err != nil {
+		return err
+	}
+	if min <= first {
+		return l.Truncate(max)
+	}
+	return l.cut(min)
+}
+
+//	cut removes every record from offset on, so the next append gets offset
+func (l *Log) cut(offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(l.segments) > 0 {
+		s := l.segments[len(l.segments)-1]
+		if s.baseOffset < offset {
+			break
+		}
+		if err := s.Remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[:len(l.segments)-1]
+	}
+	if len(l.segments) == 0 {
+		if err := l.newSegment(offset); err != nil {
+			return err
+		}
+		return writeManifest(l.Dir, l.manifest(false))
+	}
+
+	s := l.segments[len(l.segments)-1]
+	l.activeSegment = s
+	if s.nextOffset > offset {
+		_, pos, err := s.find(offset)
+		if err != nil {
+			return err
+		}
+		if err := s.store.Truncate(pos); err != nil {
+			return err
+		}
+		//	what's appended next goes where the cut records were
+		s.config.cache.purge(s)
+		s.index.truncate(uint32(offset - s.baseOffset))
+		if err := s.timeIndex.truncate(uint32(offset - s.baseOffset)); err != nil {
+			return err
+		}
+		s.nextOffset = offset
+	}
+	return writeManifest(l.Dir, l.manifest(false))
+}
+
+//	RaftRPC is the first byte of every connection that carries Raft traffic,
+//		so it can share a port with the gRPC server
+const RaftRPC = 1
+
+var _ raft.StreamLayer = (*StreamLayer)(nil)
+
+//	StreamLayer is the network transport for Raft, optionally over TLS
+type StreamLayer struct {
+	ln              net.Listener
+	serverTLSConfig *tls.Config
+	peerTLSConfig   *tls.Config
+	//	set from the Raft config by NewDistributedLog
+	compress           bool
+	peerBytesPerSecond uint64
+	mu                 sync.Mutex
+	throttles          map[string]*throttle
+}
+
+func NewStreamLayer(
+	ln net.Listener,
+	serverTLSConfig,
+	peerTLSConfig *tls.Config,
+) *StreamLayer {
+	return &StreamLayer{
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
+	}
+}
+
+func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial("tcp", string(addr))
+	if err != nil {
+		return nil, err
+	}
+	//	say this is a raft connection, and whether it's compressed
+	rpc := RaftRPC
+	if s.compress {
+		rpc = RaftRPCCompressed
+	}
+	if _, err = conn.Write([]byte{byte(rpc)}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	//	throttled on the wire, after compression
+	if t := s.peerThrottle(string(addr)); t != nil {
+		conn = &throttledConn{Conn: conn, throttle: t}
+	}
+	if s.peerTLSConfig != nil {
+		conn = tls.Client(conn, s.peerTLSConfig)
+	}
+	if s.compress {
+		if conn, err = newCompressedConn(conn); err != nil {
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Accept() (net.Conn, error) {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1)
+	if _, err = conn.Read(b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if b[0] != RaftRPC && b[0] != RaftRPCCompressed {
+		conn.Close()
+		return nil, fmt.Errorf("log: not a raft rpc")
+	}
+	if s.serverTLSConfig != nil {
+		conn = tls.Server(conn, s.serverTLSConfig)
+	}
+	if b[0] == RaftRPCCompressed {
+		return newCompressedConn(conn)
+	}
+	return conn, nil
+}
+
+func (s *StreamLayer) Close() error {
+	return s.ln.Close()
+}
+
+func (s *StreamLayer) Addr() net.Addr {
+	return s.ln.Addr()
+}
@@ -0,0 +1,279 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipleNodes(t *testing.T) {
+	var logs []*DistributedLog
+	nodeCount := 3
+
+	for i := 0; i < nodeCount; i++ {
+		dataDir, err := os.MkdirTemp("", "distributed-log-test")
+		require.NoError(t, err)
+		defer func(dir string) {
+			_ = os.RemoveAll(dir)
+		}(dataDir)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		config := Config{}
+		config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+		config.Raft.Bootstrap = i == 0
+		// compressed and plain connections mix, and the leader's are
+		// throttled
+		config.Raft.Compress = i == 1
+		if i == 0 {
+			config.Raft.PeerBytesPerSecond = 10 << 20
+		}
+
+		l, err := NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+		defer l.Close()
+
+		if i != 0 {
+			err = logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String())
+			require.NoError(t, err)
+		} else {
+			err = l.WaitForLeader(3 * time.Second)
+			require.NoError(t, err)
+		}
+		logs = append(logs, l)
+	}
+
+	records := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+	}
+	for _, record := range records {
+		off, err := logs[0].Append("", record)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			for j := 0; j < nodeCount; j++ {
+				got, err := logs[j].Read("", off)
+				if err != nil {
+					return false
+				}
+				record.Offset = off
+				if !reflect.DeepEqual(got.Value, record.Value) ||
+					got.Timestamp != record.Timestamp {
+					return false
+				}
+			}
+			return true
+		}, 500*time.Millisecond, 50*time.Millisecond)
+	}
+
+	servers, err := logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 3)
+	require.True(t, servers[0].IsLeader)
+	require.False(t, servers[1].IsLeader)
+	require.False(t, servers[2].IsLeader)
+
+	// followers can't take writes
+	_, err = logs[1].Append("", &api.Record{Value: []byte("nope")})
+	require.Equal(t, raft.ErrNotLeader, err)
+
+	// nor serve linearizable reads, but they can say who does
+	require.NoError(t, logs[0].VerifyRead())
+	err = logs[1].VerifyRead()
+	require.Equal(t, api.ErrNotLeader{Leader: servers[0].RpcAddr}, err)
+
+	// a node that left stops getting records
+	require.NoError(t, logs[0].Leave("1"))
+	time.Sleep(50 * time.Millisecond)
+	servers, err = logs[0].GetServers()
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+
+	off, err := logs[0].AppendBatch("", []*api.Record{{Value: []byte("third")}})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = logs[1].Read("", off[0])
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+	record, err := logs[2].Read("", off[0])
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), record.Value)
+}
+
+type snapshotSink struct {
+	bytes.Buffer
+}
+
+func (s *snapshotSink) ID() string    { return "test" }
+func (s *snapshotSink) Cancel() error { return nil }
+func (s *snapshotSink) Close() error  { return nil }
+
+func TestDistributedLogEvents(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "distributed-events-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	config := Config{}
+	config.Events.Enabled = true
+	config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = "0"
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.Bootstrap = true
+	l, err := NewDistributedLog(dataDir, config)
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+
+	// a server that's gone again before it could be reached
+	require.NoError(t, l.Join("1", "127.0.0.1:1"))
+	require.NoError(t, l.Leave("1"))
+
+	events := func() []Event {
+		var events []Event
+		for off := uint64(0); ; off++ {
+			record, err := l.Read(EventsTopic, off)
+			if err != nil {
+				return events
+			}
+			var e Event
+			require.NoError(t, json.Unmarshal(record.Value, &e))
+			events = append(events, e)
+		}
+	}
+	require.Eventually(t, func() bool {
+		return len(events()) == 3
+	}, 3*time.Second, 50*time.Millisecond)
+	require.Equal(t, []Event{
+		{Type: EventLeaderChanged, Attributes: map[string]string{
+			"node":        "0",
+			"leader_id":   "0",
+			"leader_addr": ln.Addr().String(),
+		}},
+		{Type: EventNodeJoined, Attributes: map[string]string{
+			"node":     "0",
+			"node_id":  "1",
+			"rpc_addr": "127.0.0.1:1",
+		}},
+		{Type: EventNodeLeft, Attributes: map[string]string{
+			"node":    "0",
+			"node_id": "1",
+		}},
+	}, events())
+}
+
+func TestFSMSnapshot(t *testing.T) {
+	newTopics := func() *Topics {
+		dir, err := os.MkdirTemp("", "fsm-test")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		c := Config{}
+		c.Segment.MaxStoreBytes = 64
+		topics, err := NewTopics(dir, c)
+		require.NoError(t, err)
+		t.Cleanup(func() { topics.Close() })
+		return topics
+	}
+
+	from := newTopics()
+	for i := 0; i < 5; i++ {
+		for _, topic := range []string{"a", "b"} {
+			_, err := from.Append(topic, &api.Record{Value: []byte(fmt.Sprintf("%s-%d", topic, i))})
+			require.NoError(t, err)
+		}
+	}
+	a, err := from.Topic("a")
+	require.NoError(t, err)
+	require.NoError(t, a.Truncate(1))
+
+	snap, err := (&fsm{topics: from}).Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+	snap.Release()
+
+	to := newTopics()
+	_, err = to.Append("c", &api.Record{Value: []byte("replaced")})
+	require.NoError(t, err)
+	require.NoError(t, (&fsm{topics: to}).Restore(io.NopCloser(&sink.Buffer)))
+
+	require.Equal(t, []string{"a", "b"}, to.Names())
+	for _, topic := range []string{"a", "b"} {
+		lowest, highest, err := from.Offsets(topic)
+		require.NoError(t, err)
+		gotLowest, gotHighest, err := to.Offsets(topic)
+		require.NoError(t, err)
+		require.Equal(t, lowest, gotLowest)
+		require.Equal(t, highest, gotHighest)
+		for off := lowest; off <= highest; off++ {
+			want, err := from.Read(topic, off)
+			require.NoError(t, err)
+			got, err := to.Read(topic, off)
+			require.NoError(t, err)
+			require.Equal(t, want.Value, got.Value)
+		}
+	}
+
+	// the restored topics carry on where the snapshot left off
+	off, err := to.Append("a", &api.Record{Value: []byte("a-5")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
+
+func TestLogStoreDeleteRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.InitialOffset = 1
+	s, err := newLogStore(dir, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var entries []*raft.Log
+	for i := uint64(1); i <= 6; i++ {
+		entries = append(entries, &raft.Log{Index: i, Term: 1, Data: []byte("hello world")})
+	}
+	require.NoError(t, s.StoreLogs(entries))
+
+	// a new leader's log disagrees from index 4 on
+	require.NoError(t, s.DeleteRange(4, 6))
+	last, err := s.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), last)
+	require.NoError(t, s.StoreLog(&raft.Log{Index: 4, Term: 2, Data: []byte("replaced")}))
+
+	var got raft.Log
+	require.NoError(t, s.GetLog(4, &got))
+	require.Equal(t, uint64(2), got.Term)
+	require.Equal(t, []byte("replaced"), got.Data)
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(5, &got))
+
+	// and a snapshot covers everything up to 2
+	require.NoError(t, s.DeleteRange(1, 2))
+	first, err := s.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), first)
+}
@@ -0,0 +1,136 @@
+package log
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	KeyProvider hands out the AES keys records are encrypted with at rest,
+//		for example by asking a KMS. Keys have ids so records written under
+//		an old key can still be read after rotating to a new one
+type KeyProvider interface {
+	//	CurrentKey is the key new records are encrypted with
+	CurrentKey() (id uint32, key []byte, err error)
+	//	Key returns the key with id
+	Key(id uint32) ([]byte, error)
+}
+
+//	StaticKey is a KeyProvider with a single AES-128, 192 or 256 key, whose
+//		id is 0
+type StaticKey []byte
+
+//	IsKeyError reports whether err is from a record that couldn't be
+//		decrypted, because there's no key for it or the key is wrong
+func IsKeyError(err error) bool {
+	return errors.Is(err, errKey)
+}
+
+//	ReadKeyFile reads a StaticKey from a file holding nothing but the raw key
+func ReadKeyFile(name string) (StaticKey, error) {
+	key, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(key); n != 16 && n != 24 && n != 32 {
+		return nil, fmt.Errorf("%s: AES keys are 16, 24 or 32 bytes, not %d", name, n)
+	}
+	return StaticKey(key), nil
+}
+
+func (k StaticKey) CurrentKey() (uint32, []byte, error) {
+	return 0, k, nil
+}
+
+func (k StaticKey) Key(id uint32) ([]byte, error) {
+	if id != 0 {
+		return nil, fmt.Errorf("log: no encryption key %d", id)
+	}
+	return k, nil
+}
+
+//	an encrypted entry is framed like a compressed one, with its own codec
+//		byte, then the id of the key, the nonce and the sealed entry (which
+//		may itself be compressed)
+const (
+	encryptedCodec = 0xff
+	keyIDWidth     = 4
+	nonceWidth     = 12
+)
+
+//	errKey is wrapped by every failure to decrypt a record that's whole in
+//		the store, all of which come down to not having its key. Scans that
+//		treat a bad record as torn must stop on these instead of cutting it
+var (
+	errKey    = errors.New("log: can't decrypt record")
+	errNoKeys = fmt.Errorf("%w: no key provider", errKey)
+)
+
+//	encode turns record into the bytes the store holds for it, compressed
+//		and then encrypted as the config asks
+func (s *segment) encode(record *api.Record) ([]byte, error) {
+	p, err := encodeRecord(record, s.config.Store.Compression)
+	keys := s.config.Store.Encryption.Keys
+	if err != nil || keys == nil {
+		return p, err
+	}
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2+keyIDWidth+nonceWidth, 2+keyIDWidth+nonceWidth+len(p)+gcm.Overhead())
+	out[0], out[1] = compressedMarker, encryptedCodec
+	enc.PutUint32(out[2:2+keyIDWidth], id)
+	nonce := out[2+keyIDWidth:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, nonce, p, nil), nil
+}
+
+//	decode is the inverse of encode. Entries that aren't encrypted are read
+//		as they are, so turning encryption on doesn't strand older records
+func (s *segment) decode(p []byte) (*api.Record, error) {
+	if len(p) >= 2 && p[0] == compressedMarker && p[1] == encryptedCodec {
+		keys := s.config.Store.Encryption.Keys
+		if keys == nil {
+			return nil, errNoKeys
+		}
+		if len(p) < 2+keyIDWidth+nonceWidth {
+			return nil, fmt.Errorf("log: encrypted record is too short")
+		}
+		id := enc.Uint32(p[2 : 2+keyIDWidth])
+		key, err := keys.Key(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errKey, err)
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errKey, err)
+		}
+		//	the store's checksum already passed, so a failed open means the
+		//		key is wrong rather than the record damaged
+		nonce := p[2+keyIDWidth : 2+keyIDWidth+nonceWidth]
+		if p, err = gcm.Open(nil, nonce, p[2+keyIDWidth+nonceWidth:], nil); err != nil {
+			return nil, fmt.Errorf("%w: key %d: %v", errKey, id, err)
+		}
+	}
+	return decodeRecord(p)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// rotatingKeys is a KeyProvider whose current key is the last one
+type rotatingKeys [][]byte
+
+func (k rotatingKeys) CurrentKey() (uint32, []byte, error) {
+	return uint32(len(k) - 1), k[len(k)-1], nil
+}
+
+func (k rotatingKeys) Key(id uint32) ([]byte, error) {
+	if int(id) >= len(k) {
+		return nil, fmt.Errorf("no key %d", id)
+	}
+	return k[id], nil
+}
+
+func TestLogEncryption(t *testing.T) {
+	dir, err := os.MkdirTemp("", "encryption-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	value := []byte("top secret value")
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// records written in the clear still read once encryption is on, and
+	// records written under an old key still read after rotating
+	keys := rotatingKeys{bytes.Repeat([]byte{1}, 32)}
+	c.Store.Encryption.Keys = keys
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	keys = append(keys, bytes.Repeat([]byte{2}, 16))
+	c.Store.Encryption.Keys = keys
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: value})
+	require.NoError(t, err)
+	for off := uint64(0); off < 3; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, value, record.Value)
+	}
+	b, err := os.ReadFile(log.activeSegment.store.Name())
+	require.NoError(t, err)
+	require.Equal(t, 1, bytes.Count(b, value))
+	require.NoError(t, log.Close())
+
+	// without the right keys encrypted records can't be read, but they're
+	// kept rather than cut off as if they were torn
+	for _, k := range []KeyProvider{nil, StaticKey(bytes.Repeat([]byte{3}, 32))} {
+		c.Store.Encryption.Keys = k
+		log, err = NewLog(dir, c)
+		require.NoError(t, err)
+		_, err = log.Read(0)
+		require.NoError(t, err)
+		_, err = log.Read(2)
+		require.ErrorIs(t, err, errKey)
+		require.NoError(t, log.Close())
+	}
+
+	c.Store.Encryption.Keys = keys
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+}
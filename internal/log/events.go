@@ -0,0 +1,89 @@
+package log
+
+import (
+	"encoding/json"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	EventsTopic is where Topics publishes events about the topics themselves
+//		when Config.Events is enabled. It's consumed like any other topic, but
+//		only Publish writes to it
+const EventsTopic = "__events"
+
+type EventType string
+
+const (
+	EventTopicCreated   EventType = "topic_created"
+	EventTopicDeleted   EventType = "topic_deleted"
+	EventSegmentSealed  EventType = "segment_sealed"
+	EventSegmentRemoved EventType = "segment_removed"
+	//	from a DistributedLog, as this node saw them: the cluster got a new
+	//		leader, or membership reported a server joining or leaving. Their
+	//		Attributes say which node saw it and which server it's about
+	EventLeaderChanged EventType = "leader_changed"
+	EventNodeJoined    EventType = "node_joined"
+	EventNodeLeft      EventType = "node_left"
+)
+
+//	Event is the JSON value of each record in EventsTopic. Its time is the
+//		record's timestamp
+type Event struct {
+	Type  EventType `json:"type"`
+	Topic string    `json:"topic,omitempty"`
+	//	the segment, for segment events
+	BaseOffset uint64 `json:"base_offset,omitempty"`
+	NextOffset uint64 `json:"next_offset,omitempty"`
+	//	anything else worth knowing, for event types from outside the log
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+//	Publish appends e to EventsTopic. It does nothing when events are disabled
+func (t *Topics) Publish(e Event) error {
+	if t.events == nil {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = t.events.Append(&api.Record{Value: b})
+	return err
+}
+
+//	eventObserver publishes the segment changes of one topic
+type eventObserver struct {
+	topics *Topics
+	topic  string
+}
+
+func (o *eventObserver) SegmentSealed(info SegmentInfo) {
+	o.publish(EventSegmentSealed, info)
+}
+
+func (o *eventObserver) SegmentRemoved(info SegmentInfo) {
+	o.publish(EventSegmentRemoved, info)
+}
+
+func (o *eventObserver) AllowDelete(SegmentInfo) bool {
+	return true
+}
+
+//	events are best effort; a failed publish mustn't fail the write that
+//		caused it
+func (o *eventObserver) publish(typ EventType, info SegmentInfo) {
+	_ = o.topics.Publish(Event{
+		Type:       typ,
+		Topic:      o.topic,
+		BaseOffset: info.BaseOffset,
+		NextOffset: info.NextOffset,
+	})
+}
+
+//	observe has the events for l published. The events topic itself isn't
+//		observed, since publishing about it would write to it again
+func (t *Topics) observe(name string, l *Log) {
+	if t.Config.Events.Enabled && name != EventsTopic {
+		l.RegisterObserver(&eventObserver{topics: t, topic: name})
+	}
+}
@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	KeyExtractor derives the key compaction keeps the newest record for.
+//		Records it returns no key for are always kept
+type KeyExtractor interface {
+	Key(record *api.Record) []byte
+}
+
+//	RecordKey compacts by the record's own key. It's what a topic without an
+//		extractor uses
+type RecordKey struct{}
+
+func (RecordKey) Key(record *api.Record) []byte {
+	return record.Key
+}
+
+//	JSONField compacts by a field of records whose values are JSON objects,
+//		named by a dotted path; a number in the path indexes an array. A
+//		string field is the key as it is, anything else its JSON. Values
+//		that aren't JSON or don't have the field have no key
+type JSONField string
+
+func (f JSONField) Key(record *api.Record) []byte {
+	d := json.NewDecoder(bytes.NewReader(record.Value))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(string(f), ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[name]
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	default:
+		b, _ := json.Marshal(v)
+		return b
+	}
+}
+
+//	ParseKeyExtractor reads an extractor from its name: "key" for RecordKey,
+//		or "json:" and a path for JSONField
+func ParseKeyExtractor(spec string) (KeyExtractor, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "key":
+		return RecordKey{}, nil
+	case "json":
+		if arg == "" {
+			return nil, fmt.Errorf("log: key extractor %q has no field path", spec)
+		}
+		return JSONField(arg), nil
+	}
+	return nil, fmt.Errorf("log: unknown key extractor %q", spec)
+}
+
+//	compactionKey is the key record is compacted by on this log
+func (l *Log) compactionKey(record *api.Record) []byte {
+	if l.Config.Compaction.Key == nil {
+		return record.Key
+	}
+	return l.Config.Compaction.Key.Key(record)
+}
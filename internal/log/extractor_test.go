@@ -0,0 +1,74 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	value := []byte(`{"user":{"id":"u1","n":7},"tags":["x","y"]}`)
+	for spec, want := range map[string]string{
+		"key":                 "k",
+		"json:user.id":        "u1",
+		"json:user.n":         "7",
+		"json:user":           `{"id":"u1","n":7}`,
+		"json:tags.1":         "y",
+		"json:tags.2":         "",
+		"json:missing":        "",
+		"json:user.id.deeper": "",
+	} {
+		e, err := ParseKeyExtractor(spec)
+		require.NoError(t, err)
+		got := e.Key(&api.Record{Key: []byte("k"), Value: value})
+		require.Equal(t, want, string(got), spec)
+	}
+	require.Nil(t, JSONField("id").Key(&api.Record{Value: []byte("not json")}))
+
+	for _, spec := range []string{"", "json", "json:", "header:id"} {
+		_, err := ParseKeyExtractor(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestTopicKeyExtractor(t *testing.T) {
+	dir, err := os.MkdirTemp("", "extractor-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Compaction.TopicKeys = map[string]KeyExtractor{"users": JSONField("id")}
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+	defer topics.Close()
+
+	// the same records, without keys of their own: only users compacts them
+	for _, topic := range []string{"users", "other"} {
+		for _, id := range []string{"a", "b", "a", "a", "c"} {
+			_, err := topics.Append(topic, &api.Record{
+				Value: []byte(fmt.Sprintf(`{"id":%q}`, id)),
+			})
+			require.NoError(t, err)
+		}
+	}
+	for topic, want := range map[string]int{"users": 3, "other": 5} {
+		l, err := topics.Topic(topic)
+		require.NoError(t, err)
+		require.NoError(t, l.Compact())
+		var n int
+		for off := uint64(0); ; {
+			record, err := l.Read(off)
+			if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+				break
+			}
+			require.NoError(t, err)
+			n++
+			off = record.Offset + 1
+		}
+		require.Equal(t, want, n, topic)
+	}
+}
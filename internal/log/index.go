@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"os"
+	"sort"
 
 	"github.com/tysonmote/gommap"
 )
@@ -17,15 +18,19 @@ var (
 //		underlying file
 //		in-memory map of file
 //		current size
+//		entries written since the mmap was last synced
 type index struct {
-	file *os.File
-	mmap gommap.MMap
-	size uint64
+	file     *os.File
+	mmap     gommap.MMap
+	size     uint64
+	dirty    uint64
+	maxDirty uint64
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		maxDirty: c.Segment.IndexMaxDirtyEntries,
 	}
 	
 	fi, err := os.Stat(f.Name())
@@ -62,6 +67,7 @@ func (i *index) Close() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
+	i.dirty = 0
 
 	//	data in mmap has been flushed to file, now file will be flushed to stable
 	//		storage
@@ -105,6 +111,41 @@ func (i *index) Read(offset int64) (out uint32, pos uint64, err error) {
 	return out, pos, nil
 }
 
+//	Lookup returns the last entry at or before the relative offset, which is
+//		where a scan of the store for that offset starts. Entries are in offset
+//		order, but sparse indexing and compaction leave gaps, so when slot N
+//		doesn't hold offset N it falls back to a binary search. An offset
+//		before the first entry gets the first entry
+func (i *index) Lookup(offset uint32) (out uint32, pos uint64, err error) {
+	entries := i.size / entWidth
+	if entries == 0 {
+		return 0, 0, io.EOF
+	}
+	n := uint64(offset)
+	if n >= entries || i.offsetAt(n) != offset {
+		n = uint64(sort.Search(int(entries), func(k int) bool {
+			return i.offsetAt(uint64(k)) > offset
+		}))
+		if n > 0 {
+			n--
+		}
+	}
+	return i.Read(int64(n))
+}
+
+//	truncate drops the entries for relative offsets from offset on
+func (i *index) truncate(offset uint32) {
+	n := sort.Search(int(i.size/entWidth), func(k int) bool {
+		return i.offsetAt(uint64(k)) >= offset
+	})
+	i.size = uint64(n) * entWidth
+}
+
+//	offsetAt returns the relative offset in entry n
+func (i *index) offsetAt(n uint64) uint32 {
+	return enc.Uint32(i.mmap[n*entWidth : n*entWidth+offWidth])
+}
+
 //	Write appends a new entry and updates the size of the index
 func (i *index) Write(offset uint32, pos uint64) error {
 	//	check whether given a new entry the file will grow beyond the size of the mmap
@@ -117,6 +158,26 @@ func (i *index) Write(offset uint32, pos uint64) error {
 	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
 	// update size of index 
 	i.size += uint64(entWidth)
+
+	//	bound how many entries can be lost on a crash by forcing a sync once
+	//		enough of them pile up
+	i.dirty++
+	if i.maxDirty > 0 && i.dirty >= i.maxDirty {
+		return i.Sync(gommap.MS_SYNC)
+	}
+	return nil
+}
+
+//	Sync flushes dirty pages of the mmap to the file. MS_ASYNC only schedules
+//		the write-back, MS_SYNC waits for it to finish
+func (i *index) Sync(flags gommap.SyncFlags) error {
+	if i.dirty == 0 {
+		return nil
+	}
+	if err := i.mmap.Sync(flags); err != nil {
+		return err
+	}
+	i.dirty = 0
 	return nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tysonmote/gommap"
 )
 
 func TestIndex(t *testing.T) {
@@ -51,3 +52,25 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexSyncsDirtyEntries(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "index_sync_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	c.Segment.IndexMaxDirtyEntries = 2
+	idx, err := newIndex(f, c)
+	require.NoError(t, err)
+
+	require.NoError(t, idx.Write(0, 0))
+	require.Equal(t, uint64(1), idx.dirty)
+	require.NoError(t, idx.Write(1, 10))
+	require.Equal(t, uint64(0), idx.dirty)
+
+	require.NoError(t, idx.Write(2, 20))
+	require.NoError(t, idx.Sync(gommap.MS_ASYNC))
+	require.Equal(t, uint64(0), idx.dirty)
+	require.NoError(t, idx.Close())
+}
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"path"
+)
+
+//	segments that fail validation and can't be repaired are moved here so the
+//		rest of the log can still open. They're kept for an operator to inspect
+const quarantineDir = "quarantine"
+
+//	IntegrityReport summarises what the startup check found, by base offset
+type IntegrityReport struct {
+	//	false when the previous shutdown was clean and the check was skipped
+	Checked     bool
+	Validated   []uint64
+	Repaired    []uint64
+	Quarantined []uint64
+}
+
+//	Integrity returns the report from when the log was opened
+func (l *Log) Integrity() IntegrityReport {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.integrity
+}
+
+//	checkIntegrity validates every segment, trimming index tails that point
+//		at data the store doesn't have and quarantining segments that are
+//		damaged beyond that. It runs before the log is in use, so no locking
+func (l *Log) checkIntegrity() error {
+	l.integrity = IntegrityReport{Checked: true}
+	if len(l.segments) == 0 {
+		return nil
+	}
+	last := l.segments[len(l.segments)-1]
+
+	var (
+		segments []*segment
+		next     uint64
+	)
+	for _, s := range l.segments {
+		valid, damaged, err := s.validate()
+		if err != nil {
+			return err
+		}
+		claimed := s.baseOffset + s.index.size/entWidth
+		if claimed > next {
+			next = claimed
+		}
+		if damaged {
+			if err := s.Move(path.Join(l.Dir, quarantineDir)); err != nil {
+				return err
+			}
+			l.integrity.Quarantined = append(l.integrity.Quarantined, s.baseOffset)
+			l.Config.Logger.Error("segment quarantined", "base_offset", s.baseOffset, "valid_entries", valid)
+			continue
+		}
+		repaired := valid*entWidth != s.index.size
+		if repaired {
+			s.index.size = valid * entWidth
+			//	compacted segments have gaps, so go by the last good entry
+			//		rather than by how many there are
+			s.nextOffset = s.baseOffset
+			if off, _, err := s.index.Read(-1); err == nil {
+				s.nextOffset += uint64(off) + 1
+			}
+		}
+		if s.nextOffset > next {
+			next = s.nextOffset
+		}
+		//	only the segment that was being written to when we went down can
+		//		have records the index doesn't know about, or a torn one
+		if s == last {
+			recovered, err := s.recover()
+			if err != nil {
+				return err
+			}
+			repaired = repaired || recovered
+		}
+		//	a torn block that the double-write copy put back
+		repaired = repaired || s.store.restored
+		//	and forget the times of records that didn't make it
+		if err := s.timeIndex.truncate(uint32(s.nextOffset - s.baseOffset)); err != nil {
+			return err
+		}
+		if repaired {
+			l.integrity.Repaired = append(l.integrity.Repaired, s.baseOffset)
+			l.Config.Logger.Warn("segment repaired", "base_offset", s.baseOffset, "next_offset", s.nextOffset)
+		} else {
+			l.integrity.Validated = append(l.integrity.Validated, s.baseOffset)
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	if len(segments) > 0 {
+		l.activeSegment = segments[len(segments)-1]
+	}
+
+	//	if the active segment was quarantined carry on after the offsets it
+	//		claimed, so offsets are never handed out twice
+	if len(segments) == 0 || l.activeSegment != last {
+		l.activeSegment = nil
+		return l.newSegment(next)
+	}
+	return nil
+}
+
+//	validate walks the index and checks that the entries' relative offsets
+//		(which compaction can leave gaps in) and positions go up and that each
+//		points at a whole record in the store. It returns
+//		how many leading entries are good. An unclean shutdown leaves the
+//		index padded with zeroes (or with entries for records the store never
+//		got), which can simply be trimmed; anything that looks like a real
+//		entry after a bad one means the segment is damaged
+func (s *segment) validate() (valid uint64, damaged bool, err error) {
+	entries := s.index.size / entWidth
+	size := make([]byte, lenWidth)
+	var prev, prevOff uint64
+	for ; valid < entries; valid++ {
+		at := valid * entWidth
+		off := enc.Uint32(s.index.mmap[at : at+offWidth])
+		pos := enc.Uint64(s.index.mmap[at+offWidth : at+entWidth])
+		if valid == 0 && pos != 0 {
+			break
+		}
+		if valid > 0 && (uint64(off) <= prevOff || pos <= prev) {
+			break
+		}
+		if pos+headerWidth > s.store.size {
+			break
+		}
+		if _, err := s.store.ReadAt(size, int64(pos)); err != nil {
+			return 0, false, err
+		}
+		if pos+headerWidth+enc.Uint64(size) > s.store.size {
+			break
+		}
+		prev, prevOff = pos, uint64(off)
+	}
+
+	for k := valid; k < entries; k++ {
+		for _, b := range s.index.mmap[k*entWidth : (k+1)*entWidth] {
+			if b != 0 {
+				return valid, true, nil
+			}
+		}
+	}
+	return valid, false, nil
+}
+
+//	recover scans the store past the last indexed record. Whole records the
+//		index missed are indexed again (as far as sparse indexing wants them
+//		indexed); anything after the last whole record (a torn write) is cut
+//		off the store. It reports whether it changed anything
+func (s *segment) recover() (bool, error) {
+	//	start over from what the index knows about
+	s.nextOffset = s.baseOffset
+	if off, _, err := s.index.Read(-1); err == nil {
+		s.nextOffset += uint64(off) + 1
+	}
+	indexed := s.index.size
+	//	a record that fails its checksum, or isn't the one we expect next,
+	//		is treated as torn along with everything after it
+	pos, err := s.walk(func(offset, pos uint64) bool {
+		if s.indexRecord(offset, pos) != nil {
+			return false
+		}
+		s.nextOffset = offset + 1
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+
+	changed := s.index.size != indexed
+	if pos < s.store.size {
+		if err := s.store.Truncate(pos); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
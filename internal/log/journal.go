@@ -0,0 +1,112 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+)
+
+const journalFile = "topics.journal"
+
+const (
+	opCreateTopic = "create"
+	opDeleteTopic = "delete"
+)
+
+//	journalEntry is one line of the topic journal. An operation is written
+//		once when it starts and again with Done set when it has finished
+type journalEntry struct {
+	Seq   uint64 `json:"seq"`
+	Op    string `json:"op"`
+	Topic string `json:"topic"`
+	Done  bool   `json:"done,omitempty"`
+}
+
+//	journal is an append-only record of topic operations. Anything that was
+//		started but not finished when the process died is finished on the
+//		next startup, so a topic is never left half created or half deleted
+type journal struct {
+	file *os.File
+	seq  uint64
+}
+
+//	openJournal opens the journal in dir and returns the operations that were
+//		started but never finished, oldest first
+func openJournal(dir string) (*journal, []journalEntry, error) {
+	name := path.Join(dir, journalFile)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		started []journalEntry
+		done    = make(map[uint64]bool)
+		seq     uint64
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e journalEntry
+		//	a torn final line is an operation that never got going
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Seq > seq {
+			seq = e.Seq
+		}
+		if e.Done {
+			done[e.Seq] = true
+			continue
+		}
+		started = append(started, e)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	var pending []journalEntry
+	for _, e := range started {
+		if !done[e.Seq] {
+			pending = append(pending, e)
+		}
+	}
+	return &journal{file: f, seq: seq}, pending, nil
+}
+
+func (j *journal) write(e journalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+//	begin durably records that op is about to be applied to topic
+func (j *journal) begin(op, topic string) (journalEntry, error) {
+	j.seq++
+	e := journalEntry{Seq: j.seq, Op: op, Topic: topic}
+	return e, j.write(e)
+}
+
+//	commit records that e has been fully applied
+func (j *journal) commit(e journalEntry) error {
+	e.Done = true
+	return j.write(e)
+}
+
+//	reset empties the journal once every operation in it has been applied
+func (j *journal) reset() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
@@ -8,8 +8,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/tysonmote/gommap"
 )
 
 type Log struct {
@@ -21,6 +24,15 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+	observers     []SegmentObserver
+	integrity     IntegrityReport
+	//	closed to stop the log's background goroutines
+	done chan struct{}
+	//	held by Compact, so two compactions don't build in the same place
+	compacting sync.Mutex
+	//	sampled reads checked by shadowRead, and how many disagreed
+	shadowReads      atomic.Uint64
+	shadowMismatches atomic.Uint64
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -33,6 +45,14 @@ func NewLog(dir string, c Config) (*Log, error) {
 		c.Segment.MaxStoreBytes = 1024
 	}
 
+	if c.Retention.CheckInterval == 0 {
+		c.Retention.CheckInterval = time.Minute
+	}
+	c.Logger = c.logger()
+	if c.cache == nil && c.Store.DecodeCacheBytes > 0 {
+		c.cache = newRecordCache(c.Store.DecodeCacheBytes)
+	}
+
 	l := &Log{
 		Dir:    dir,
 		Config: c,
@@ -42,21 +62,38 @@ func NewLog(dir string, c Config) (*Log, error) {
 }
 
 func (l *Log) setup() error {
-	files, err := os.ReadDir(l.Dir)
-	if err != nil {
+	//	a compaction that was cut short may still have a segment to move in
+	if err := finishCompaction(l.Dir); err != nil {
 		return err
 	}
-
 	var baseOffsets []uint64
-	//	iterate over all the segement files for the log to obtain all
-	//		baseOffsets currenlt managed by the log
-	for _, file := range files {
-		offStr := strings.TrimSuffix(
-			file.Name(),
-			path.Ext(file.Name()),
-		)
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+	//	a manifest left behind by a clean shutdown already lists every segment
+	//		and where the newest one ends, so there's no need to go looking
+	//		for them
+	m, err := readManifest(l.Dir)
+	clean := err == nil && m.CleanShutdown && len(m.Segments) > 0 &&
+		m.HighWatermark >= m.Segments[len(m.Segments)-1]
+	if clean {
+		baseOffsets = m.Segments
+	} else {
+		files, err := os.ReadDir(l.Dir)
+		if err != nil {
+			return err
+		}
+		//	iterate over all the segement files for the log to obtain all
+		//		baseOffsets currenlt managed by the log. Each segment has a store
+		//		and an index file; only the store files are looked at
+		for _, file := range files {
+			if path.Ext(file.Name()) != ".store" {
+				continue
+			}
+			offStr := strings.TrimSuffix(
+				file.Name(),
+				path.Ext(file.Name()),
+			)
+			off, _ := strconv.ParseUint(offStr, 10, 0)
+			baseOffsets = append(baseOffsets, off)
+		}
 	}
 
 	//	sort offsets
@@ -64,18 +101,64 @@ func (l *Log) setup() error {
 		return baseOffsets[i] < baseOffsets[j]
 	})
 	//	create a segment for each offset
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+	for _, off := range baseOffsets {
+		if err := l.openSegment(off, clean); err != nil {
+			return err
+		}
+	}
+	if clean {
+		l.activeSegment.nextOffset = m.HighWatermark
+	}
+	//	after a crash (or on a log we know nothing about) make sure the
+	//		segments are sound before using them
+	l.integrity = IntegrityReport{}
+	if !clean {
+		if err := l.checkIntegrity(); err != nil {
 			return err
 		}
-		i++
 	}
 	//	if there were no existing offsets, try to create the initial segement
 	if l.segments == nil {
-		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
+		if err := l.newSegment(l.Config.Segment.InitialOffset); err != nil {
 			return err
 		}
 	}
+	//	a sealed segment runs up to where the next one starts, even when
+	//		compaction has removed the records at its end
+	for i := 0; i+1 < len(l.segments); i++ {
+		l.segments[i].nextOffset = l.segments[i+1].baseOffset
+	}
+
+	//	the log is open now; until Close runs the manifest must not claim a
+	//		clean shutdown
+	if err := writeManifest(l.Dir, l.manifest(false)); err != nil {
+		return err
+	}
+	l.done = make(chan struct{})
+	if l.Config.Manifest.Interval > 0 {
+		go l.checkpoint(l.Config.Manifest.Interval, l.done)
+	}
+	if l.Config.Segment.IndexSyncInterval > 0 {
+		go l.syncIndexes(l.Config.Segment.IndexSyncInterval, l.done)
+	}
+	if p := l.Config.Store.SyncPolicy; p.Mode == SyncInterval && p.Interval > 0 {
+		go l.syncStores(p.Interval, l.done)
+	}
+	if l.Config.Segment.WarmSegments > 0 {
+		go l.warm(l.Config.Segment.WarmSegments, l.done)
+	}
+	if l.Config.Retention.MaxAge > 0 || l.Config.Retention.MaxLogBytes > 0 {
+		go l.enforceRetention(l.Config.Retention.CheckInterval, l.done)
+	}
+	if l.Config.Compaction.Interval > 0 {
+		go l.enforceCompaction(l.Config.Compaction.Interval, l.done)
+	}
+	if l.Config.Trash.GracePeriod > 0 {
+		if err := purgeTrash(path.Join(l.Dir, trashDir), l.Config.Trash.GracePeriod); err != nil {
+			return err
+		}
+		go l.emptyTrash(l.Config.Trash.GracePeriod, l.done)
+	}
 
 	return nil
 }
@@ -88,42 +171,142 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 		return 0, err
 	}
 	if l.activeSegment.IsMaxed() {
-		err = l.newSegment(offset + 1)
+		err = l.roll(offset + 1)
 	}
 	return offset, err
 }
 
+//	AppendBatch appends records in order under a single lock, rolling over to
+//		new segments as they fill, and returns the offset of each record
+func (l *Log) AppendBatch(records []*api.Record) ([]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offsets := make([]uint64, 0, len(records))
+	for len(records) > 0 {
+		//	a segment reopened after a restart may already be full
+		if l.activeSegment.IsMaxed() {
+			if err := l.roll(l.activeSegment.nextOffset); err != nil {
+				return nil, err
+			}
+		}
+		offs, err := l.activeSegment.AppendBatch(records)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offs...)
+		records = records[len(offs):]
+	}
+	//	leave a fresh segment behind for the next append, as Append does
+	if l.activeSegment.IsMaxed() {
+		if err := l.roll(l.activeSegment.nextOffset); err != nil {
+			return nil, err
+		}
+	}
+	return offsets, nil
+}
+
+//	roll seals the active segment and starts a new one at offset. The caller
+//		must hold the write lock
+func (l *Log) roll(offset uint64) error {
+	sealed := l.activeSegment
+	//	flush the sealed segment so observers see every record in the file,
+	//		and fsync it too unless durability was traded away
+	flush := sealed.store.Flush
+	if l.Config.Store.SyncPolicy.Mode != SyncNone {
+		flush = sealed.store.Sync
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := l.newSegment(offset); err != nil {
+		return err
+	}
+	if err := writeManifest(l.Dir, l.manifest(false)); err != nil {
+		return err
+	}
+	l.Config.Logger.Info("segment sealed",
+		"base_offset", sealed.baseOffset,
+		"next_offset", sealed.nextOffset,
+		"store_bytes", sealed.store.size,
+	)
+	for _, o := range l.observers {
+		o.SegmentSealed(sealed.info())
+	}
+	return nil
+}
+
+// RegisterObserver adds an observer that is told about sealed segments and may veto their deletion
+func (l *Log) RegisterObserver(o SegmentObserver) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.observers = append(l.observers, o)
+}
+
+// allowDelete asks every observer whether the segment may be removed
+func (l *Log) allowDelete(s *segment) bool {
+	for _, o := range l.observers {
+		if !o.AllowDelete(s.info()) {
+			return false
+		}
+	}
+	return true
+}
+
 func (l *Log) Read(offset uint64) (*api.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	var s *segment
-	//	find the segement that would contain the offset
-	for _, segment := range l.segments {
-		if segment.baseOffset <= offset && offset < segment.nextOffset {
-			s = segment
-			break
-		}
+	if offset < l.segments[0].baseOffset {
+		return nil, l.outOfRange(offset)
 	}
-	//	if the segment wasn't found return an error
-	//	removed second condition because this shouldn't ever happen given the second
-	//		condition of the search above.
-	if s == nil { //|| s.nextOffset <= offset {
-		return nil, api.ErrOffsetOutOfRange{Offset: offset}
+	//	find the segement that would contain the offset. Compaction can leave
+	//		holes in the log, so if the record is gone carry on to the next one
+	//		there is, which may be in a later segment
+	from := offset
+	for _, s := range l.segments {
+		if from >= s.nextOffset {
+			continue
+		}
+		if from < s.baseOffset {
+			from = s.baseOffset
+		}
+		record, err := s.Read(from)
+		if err == io.EOF {
+			continue
+		}
+		if err == nil {
+			l.shadowRead(s, from, record)
+		}
+		return record, err
 	}
+	return nil, l.outOfRange(offset)
+}
 
-	//	read the segement to get the record at the offset
-	return s.Read(offset)
+// outOfRange builds the error for an offset the log has no record for. The caller must hold the lock
+func (l *Log) outOfRange(offset uint64) error {
+	err := api.ErrOffsetOutOfRange{
+		Offset: offset,
+		Lowest: l.segments[0].baseOffset,
+	}
+	if next := l.activeSegment.nextOffset; next > 0 {
+		err.Highest = next - 1
+	}
+	return err
 }
 
 func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.done != nil {
+		close(l.done)
+		l.done = nil
+	}
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
-	return nil
+	//	everything is on disk, so the next startup can trust the manifest
+	return writeManifest(l.Dir, l.manifest(true))
 }
 
 func (l *Log) Remove() error {
@@ -156,21 +339,86 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return offset - 1, nil
 }
 
+//	NextOffset is the offset the next record appended will get
+func (l *Log) NextOffset() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[len(l.segments)-1].nextOffset, nil
+}
+
+//	OffsetForTime returns the offset of the first record with a timestamp at
+//		or after timestamp. When every record is older it's the offset the
+//		next record will get, so reading from there waits for new ones
+func (l *Log) OffsetForTime(timestamp int64) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, s := range l.segments {
+		if s.maxTimestamp < timestamp {
+			continue
+		}
+		offset, err := s.offsetForTime(timestamp)
+		if err == io.EOF {
+			continue
+		}
+		return offset, err
+	}
+	return l.segments[len(l.segments)-1].nextOffset, nil
+}
+
+//	Size returns the bytes of records and index entries in the log
+func (l *Log) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var size uint64
+	for _, s := range l.segments {
+		size += s.store.size + s.index.size + s.timeIndex.size()
+	}
+	return size
+}
+
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	var segments []*segment
+	vetoed := false
+	removed := 0
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
-			if err := s.Remove(); err != nil {
+		//	once a segment is kept every later one must be kept too, otherwise
+		//		the log would have a hole in it
+		if !vetoed && s.nextOffset <= lowest+1 && s != l.activeSegment {
+			if !l.allowDelete(s) {
+				vetoed = true
+				segments = append(segments, s)
+				continue
+			}
+			if err := l.removeSegment(s); err != nil {
 				return err
 			}
+			removed++
 			continue
 		}
 		segments = append(segments, s)
 	}
 	l.segments = segments
-	return nil
+	if removed > 0 || vetoed {
+		l.Config.Logger.Info("log truncated",
+			"lowest", lowest,
+			"removed_segments", removed,
+			"vetoed", vetoed,
+		)
+	}
+	return writeManifest(l.Dir, l.manifest(false))
+}
+
+//	Segments describes the log's segments, oldest first
+func (l *Log) Segments() []SegmentInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	infos := make([]SegmentInfo, len(l.segments))
+	for i, s := range l.segments {
+		infos[i] = s.info()
+	}
+	return infos
 }
 
 func (l *Log) Reader() io.Reader {
@@ -195,11 +443,68 @@ func (o *originReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// syncStores periodically fsyncs the active segment for the SyncInterval policy
+func (l *Log) syncStores(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.mu.RLock()
+			select {
+			case <-done:
+				l.mu.RUnlock()
+				return
+			default:
+			}
+			if err := l.activeSegment.store.Sync(); err != nil {
+				l.Config.Logger.Error("store sync failed", "err", err)
+			}
+			l.mu.RUnlock()
+		}
+	}
+}
+
+// syncIndexes periodically schedules write-back of dirty index pages
+func (l *Log) syncIndexes(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			//	writes to the index happen under the write lock, so holding
+			//		the read lock keeps them out while syncing
+			l.mu.RLock()
+			select {
+			case <-done:
+				l.mu.RUnlock()
+				return
+			default:
+			}
+			for _, s := range l.segments {
+				if err := s.index.Sync(gommap.MS_ASYNC); err != nil {
+					l.Config.Logger.Error("index sync failed", "base_offset", s.baseOffset, "err", err)
+				}
+			}
+			l.mu.RUnlock()
+		}
+	}
+}
+
 func (l *Log) newSegment(offset uint64) error {
-	s, err := newSegment(l.Dir, offset, l.Config)
+	return l.openSegment(offset, false)
+}
+
+func (l *Log) openSegment(offset uint64, clean bool) error {
+	s, err := openSegment(l.Dir, offset, l.Config, clean)
 	if err != nil {
 		return err
 	}
+	l.Config.Logger.Debug("segment opened", "dir", l.Dir, "base_offset", offset)
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
 	return nil
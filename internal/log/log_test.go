@@ -1,9 +1,15 @@
 package log
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"path"
+	"sync"
 	"testing"
+	"time"
 
 	api "github.com/NathanClassen/hydralog/api/v1"
 	"github.com/stretchr/testify/require"
@@ -19,6 +25,26 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"observer vetoes truncate":          testObserverVeto,
+		"manifest tracks clean shutdown":    testManifest,
+		"truncate moves segments to trash":  testTruncateTrash,
+		"integrity repairs index tail":      testIntegrityRepair,
+		"integrity quarantines damage":      testIntegrityQuarantine,
+		"append batch spans segments":       testAppendBatch,
+		"retention drops expired segments":  testRetentionMaxAge,
+		"retention caps log size":           testRetentionMaxBytes,
+		"recovery truncates a torn record":  testRecoverTornRecord,
+		"recovery rebuilds the index tail":  testRecoverIndexTail,
+		"compaction keeps newest per key":   testCompact,
+		"reads go on during compaction":     testCompactReads,
+		"shadow reads catch index drift":    testShadowRead,
+		"sparse index skips entries":        testSparseIndex,
+		"rolls are logged":                  testLogger,
+		"offset for time":                   testOffsetForTime,
+		"snapshot and restore":              testSnapshotRestore,
+		"next offset tells empty from one":  testNextOffset,
+		"segments fit an existing log":      testFitSegments,
+		"clean shutdown trusts watermark":   testTrustWatermark,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "store-test")
@@ -26,7 +52,7 @@ func TestLog(t *testing.T) {
 			defer os.RemoveAll(dir)
 
 			c := Config{}
-			c.Segment.MaxStoreBytes = 32
+			c.Segment.MaxStoreBytes = 64
 			log, err := NewLog(dir, c)
 			require.NoError(t, err)
 
@@ -35,6 +61,82 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func testTrustWatermark(t *testing.T, o *Log) {
+	require.NoError(t, o.Close())
+	//	with a sparse index only the first record is indexed, so nothing but
+	//		a read through the store, or the manifest, knows where it ends
+	c := o.Config
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.IndexIntervalBytes = 1024
+	l, err := NewLog(o.Dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	l, err = NewLog(o.Dir, c)
+	require.NoError(t, err)
+	next, err := l.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), next)
+	require.NoError(t, l.Close())
+
+	//	the store isn't read after a clean close: the watermark alone says
+	//		where the log ends
+	m, err := readManifest(o.Dir)
+	require.NoError(t, err)
+	m.HighWatermark = 2
+	require.NoError(t, writeManifest(o.Dir, m))
+	l, err = NewLog(o.Dir, c)
+	require.NoError(t, err)
+	next, err = l.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), next)
+	require.NoError(t, l.Close())
+
+	//	after a crash it is, and finds all three
+	m.CleanShutdown = false
+	require.NoError(t, writeManifest(o.Dir, m))
+	l, err = NewLog(o.Dir, c)
+	require.NoError(t, err)
+	next, err = l.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), next)
+	require.NoError(t, l.Close())
+}
+
+func testFitSegments(t *testing.T, o *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := o.Append(append)
+		require.NoError(t, err)
+	}
+	require.NoError(t, o.Close())
+	store, err := os.Stat(path.Join(o.Dir, "0.store"))
+	require.NoError(t, err)
+	index, err := os.Stat(path.Join(o.Dir, "0.index"))
+	require.NoError(t, err)
+
+	c, err := FitSegments(o.Dir, Config{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(store.Size()), c.Segment.MaxStoreBytes)
+	require.Equal(t, uint64(index.Size()), c.Segment.MaxIndexBytes)
+
+	//	limits that are set are kept, but an index limit is never below what's
+	//		on disk
+	given := Config{}
+	given.Segment.MaxStoreBytes = 1 << 20
+	given.Segment.MaxIndexBytes = 1
+	c, err = FitSegments(o.Dir, given)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1<<20), c.Segment.MaxStoreBytes)
+	require.Equal(t, uint64(index.Size()), c.Segment.MaxIndexBytes)
+}
+
 func testAppendRead(t *testing.T, log *Log) {
 	append := &api.Record{
 		Value: []byte("hello world"),
@@ -53,6 +155,38 @@ func testOutOfRangeErr(t *testing.T, log *Log) {
 	require.Nil(t, read)
 	apiErr := err.(api.ErrOffsetOutOfRange)
 	require.Equal(t, uint64(1), apiErr.Offset)
+
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Truncate(1))
+
+	_, err = log.Read(0)
+	apiErr = err.(api.ErrOffsetOutOfRange)
+	require.Equal(t, uint64(2), apiErr.Lowest)
+	require.Equal(t, uint64(2), apiErr.Highest)
+	_, err = log.Read(5)
+	apiErr = err.(api.ErrOffsetOutOfRange)
+	require.Equal(t, uint64(5), apiErr.Offset)
+}
+
+func testNextOffset(t *testing.T, log *Log) {
+	next, err := log.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), next)
+
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), highest)
+	next, err = log.NextOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), next)
 }
 
 func testInitExisting(t *testing.T, o *Log) {
@@ -96,7 +230,7 @@ func testReader(t *testing.T, log *Log) {
 	require.NoError(t, err)
 
 	read := &api.Record{}
-	err = proto.Unmarshal(b[lenWidth:], read)
+	err = proto.Unmarshal(b[headerWidth:], read)
 	require.NoError(t, err)
 	require.Equal(t, append.Value, read.Value)
 }
@@ -116,3 +250,510 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+type vetoObserver struct {
+	sealed []SegmentInfo
+	allow  bool
+}
+
+func (o *vetoObserver) SegmentSealed(info SegmentInfo) {
+	o.sealed = append(o.sealed, info)
+}
+
+func (o *vetoObserver) AllowDelete(info SegmentInfo) bool {
+	return o.allow
+}
+
+func testObserverVeto(t *testing.T, log *Log) {
+	o := &vetoObserver{}
+	log.RegisterObserver(o)
+
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.Len(t, o.sealed, 1)
+	require.Equal(t, uint64(0), o.sealed[0].BaseOffset)
+	require.Equal(t, uint64(2), o.sealed[0].NextOffset)
+
+	require.NoError(t, log.Truncate(1))
+	_, err := log.Read(0)
+	require.NoError(t, err)
+
+	o.allow = true
+	require.NoError(t, log.Truncate(1))
+	_, err = log.Read(0)
+	require.Error(t, err)
+}
+
+func testManifest(t *testing.T, o *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := o.Append(append)
+		require.NoError(t, err)
+	}
+
+	m, err := readManifest(o.Dir)
+	require.NoError(t, err)
+	require.False(t, m.CleanShutdown)
+	require.Equal(t, []uint64{0, 2}, m.Segments)
+
+	require.NoError(t, o.Close())
+	m, err = readManifest(o.Dir)
+	require.NoError(t, err)
+	require.True(t, m.CleanShutdown)
+	require.Equal(t, uint64(3), m.HighWatermark)
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	off, err := n.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	m, err = readManifest(o.Dir)
+	require.NoError(t, err)
+	require.False(t, m.CleanShutdown)
+}
+
+func testTruncateTrash(t *testing.T, log *Log) {
+	log.Config.Trash.GracePeriod = time.Hour
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, log.Truncate(1))
+	_, err := log.Read(0)
+	require.Error(t, err)
+
+	trash := path.Join(log.Dir, trashDir)
+	files, err := os.ReadDir(trash)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+
+	// nothing has expired yet
+	require.NoError(t, purgeTrash(trash, time.Hour))
+	files, err = os.ReadDir(trash)
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+
+	require.NoError(t, purgeTrash(trash, 0))
+	files, err = os.ReadDir(trash)
+	require.NoError(t, err)
+	require.Len(t, files, 0)
+}
+
+func testIntegrityRepair(t *testing.T, o *Log) {
+	_, err := o.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	// simulate a crash: the record reached the store but the index was never
+	// closed, so its file is still padded out to MaxIndexBytes
+	require.NoError(t, o.activeSegment.store.Flush())
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	report := n.Integrity()
+	require.True(t, report.Checked)
+	require.Equal(t, []uint64{0}, report.Repaired)
+
+	off, err := n.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	require.NoError(t, n.Close())
+
+	n, err = NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.False(t, n.Integrity().Checked)
+}
+
+func testIntegrityQuarantine(t *testing.T, o *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := o.Append(append)
+		require.NoError(t, err)
+	}
+	// point the first entry of segment 0 past the end of its store
+	enc.PutUint64(o.segments[0].index.mmap[offWidth:entWidth], 1<<20)
+	require.NoError(t, o.Close())
+	require.NoError(t, os.Remove(path.Join(o.Dir, manifestFile)))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	report := n.Integrity()
+	require.Equal(t, []uint64{0}, report.Quarantined)
+	require.Equal(t, []uint64{2}, report.Validated)
+
+	_, err = n.Read(0)
+	require.Error(t, err)
+	read, err := n.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+
+	files, err := os.ReadDir(path.Join(o.Dir, quarantineDir))
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	var records []*api.Record
+	for i := 0; i < 5; i++ {
+		records = append(records, &api.Record{Value: []byte("hello world")})
+	}
+	offsets, err := log.AppendBatch(records)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, offsets)
+	// two records fill a 64 byte store: segments start at 0, 2 and 4
+	require.Len(t, log.segments, 3)
+
+	for _, off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+
+	off, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
+
+func testRetentionMaxAge(t *testing.T, log *Log) {
+	log.Config.Retention.MaxAge = time.Hour
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{
+			Value:     []byte("hello world"),
+			Timestamp: old,
+		})
+		require.NoError(t, err)
+	}
+	_, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.NotZero(t, read.Timestamp)
+
+	// [0, 1] is expired; [2, 3] has a fresh record and stays
+	require.NoError(t, log.retain())
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func testRetentionMaxBytes(t *testing.T, log *Log) {
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// segments [0, 1], [2, 3] and [4, 5] are sealed; keep roughly the newest two
+	log.Config.Retention.MaxLogBytes = 2 * log.segments[1].store.size
+	require.NoError(t, log.retain())
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	// a single active segment is never removed
+	log.Config.Retention.MaxLogBytes = 1
+	require.NoError(t, log.retain())
+	require.Len(t, log.segments, 1)
+	require.Equal(t, uint64(6), log.segments[0].baseOffset)
+}
+
+func testRecoverTornRecord(t *testing.T, o *Log) {
+	_, err := o.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, o.Close())
+	size := o.activeSegment.store.size
+
+	// a crash halfway through writing the next record's header
+	f, err := os.OpenFile(o.activeSegment.store.Name(), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(path.Join(o.Dir, manifestFile)))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, n.Integrity().Repaired)
+	require.Equal(t, size, n.activeSegment.store.size)
+
+	off, err := n.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = n.Read(1)
+	require.NoError(t, err)
+}
+
+func testRecoverIndexTail(t *testing.T, o *Log) {
+	for i := 0; i < 2; i++ {
+		_, err := o.Append(&api.Record{Value: []byte("hello")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, o.Close())
+
+	// the store got both records but the index lost the second entry
+	require.NoError(t, os.Truncate(o.activeSegment.index.Name(), int64(entWidth)))
+	require.NoError(t, os.Remove(path.Join(o.Dir, manifestFile)))
+
+	n, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, n.Integrity().Repaired)
+	read, err := n.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), read.Value)
+}
+
+func testCompact(t *testing.T, o *Log) {
+	// two to a segment: [a b] [a -] [c a] [b x], where - has no key
+	for _, key := range []string{"a", "b", "a", "", "c", "a", "b", "x"} {
+		_, err := o.Append(&api.Record{
+			Value: []byte("hello world"),
+			Key:   []byte(key),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, o.Compact())
+
+	read := func(l *Log) []uint64 {
+		_, err := l.Read(0)
+		require.Equal(t, uint64(2), err.(api.ErrOffsetOutOfRange).Lowest)
+
+		var offsets []uint64
+		for off := uint64(2); ; {
+			record, err := l.Read(off)
+			if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+				return offsets
+			}
+			require.NoError(t, err)
+			offsets = append(offsets, record.Offset)
+			off = record.Offset + 1
+		}
+	}
+	// the first segment had nothing current left in it and is gone
+	require.Len(t, o.segments, 4)
+	require.Equal(t, []uint64{3, 4, 5, 6, 7}, read(o))
+
+	off, err := o.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+	require.NoError(t, o.Close())
+
+	l, err := NewLog(o.Dir, o.Config)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 4, 5, 6, 7, 8}, read(l))
+	require.NoError(t, l.Close())
+}
+
+//	gatedKey compacts by the record's key, once it's let through
+type gatedKey struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (g *gatedKey) Key(record *api.Record) []byte {
+	g.once.Do(func() { close(g.started) })
+	<-g.release
+	return record.Key
+}
+
+func testCompactReads(t *testing.T, o *Log) {
+	for _, key := range []string{"a", "a", "a", "b"} {
+		_, err := o.Append(&api.Record{Value: []byte("hello world"), Key: []byte(key)})
+		require.NoError(t, err)
+	}
+	key := &gatedKey{started: make(chan struct{}), release: make(chan struct{})}
+	o.Config.Compaction.Key = key
+	done := make(chan error, 1)
+	go func() { done <- o.Compact() }()
+
+	<-key.started
+	read := make(chan error, 1)
+	go func() {
+		_, err := o.Read(0)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read waited for the compaction")
+	}
+	close(key.release)
+	require.NoError(t, <-done)
+
+	// only the newest a is left of the first three, and the segment that
+	// held the other two is gone
+	lowest, err := o.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+}
+
+func testShadowRead(t *testing.T, log *Log) {
+	log.Config.ShadowRead.SampleRate = 1
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	for off := uint64(0); off < 2; off++ {
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+	reads, mismatches := log.ShadowReads()
+	require.Equal(t, uint64(2), reads)
+	require.Equal(t, uint64(0), mismatches)
+
+	// point the first index entry at the second record
+	idx := log.segments[0].index
+	copy(idx.mmap[offWidth:entWidth], idx.mmap[entWidth+offWidth:2*entWidth])
+	_, err := log.Read(0)
+	require.NoError(t, err)
+	reads, mismatches = log.ShadowReads()
+	require.Equal(t, uint64(3), reads)
+	require.Equal(t, uint64(1), mismatches)
+}
+
+func testSparseIndex(t *testing.T, o *Log) {
+	require.NoError(t, o.Close())
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.IndexIntervalBytes = 256
+	dir := path.Join(o.Dir, "sparse")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := uint64(0); i < 20; i++ {
+		off, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+		require.Equal(t, i, off)
+	}
+	entries := log.activeSegment.index.size / entWidth
+	require.Less(t, entries, uint64(5))
+
+	read := func(l *Log) {
+		for off := uint64(0); off < 20; off++ {
+			record, err := l.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+	}
+	read(log)
+	require.NoError(t, log.Close())
+
+	n, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, entries, n.activeSegment.index.size/entWidth)
+	off, err := n.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(19), off)
+	read(n)
+	off, err = n.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), off)
+	require.NoError(t, n.Close())
+}
+
+func testLogger(t *testing.T, o *Log) {
+	require.NoError(t, o.Close())
+	var buf bytes.Buffer
+	c := Config{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	c.Segment.MaxStoreBytes = 64
+	dir := path.Join(o.Dir, "logged")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.Contains(t, buf.String(), "msg=\"segment sealed\" base_offset=0")
+	// debug isn't enabled on the handler
+	require.NotContains(t, buf.String(), "segment opened")
+}
+
+func testOffsetForTime(t *testing.T, log *Log) {
+	// out of order, and over several segments
+	for _, ts := range []int64{10, 30, 20, 40, 35, 50} {
+		_, err := log.Append(&api.Record{Value: []byte("hello world"), Timestamp: ts})
+		require.NoError(t, err)
+	}
+	require.Greater(t, len(log.segments), 1)
+
+	check := func(l *Log) {
+		for _, want := range []struct {
+			ts     int64
+			offset uint64
+		}{
+			{0, 0}, {10, 0}, {11, 1}, {25, 1}, {31, 3}, {36, 3}, {45, 5}, {51, 6},
+		} {
+			off, err := l.OffsetForTime(want.ts)
+			require.NoError(t, err)
+			require.Equal(t, want.offset, off, "timestamp %d", want.ts)
+		}
+	}
+	check(log)
+	require.NoError(t, log.Close())
+
+	// the entries the time index lost are rebuilt on open
+	for _, s := range log.segments {
+		require.NoError(t, os.Truncate(s.timeIndex.Name(), 0))
+	}
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	check(n)
+	require.NoError(t, n.Close())
+}
+
+func testSnapshotRestore(t *testing.T, log *Log) {
+	for i := 0; i < 5; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Truncate(1))
+	var buf bytes.Buffer
+	require.NoError(t, log.Snapshot(&buf))
+
+	// whatever was in the log it's restored into is replaced
+	dir, err := os.MkdirTemp("", "restore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	other, err := NewLog(dir, log.Config)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := other.Append(&api.Record{Value: []byte("replaced")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, other.Restore(&buf))
+	defer other.Close()
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	got, err := other.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, lowest, got)
+	highest, err := other.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), highest)
+	for off := lowest; off <= highest; off++ {
+		record, err := other.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("record %d", off), string(record.Value))
+	}
+	off, err := other.Append(&api.Record{Value: []byte("record 5")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
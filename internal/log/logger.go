@@ -0,0 +1,23 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+//	discardHandler drops every record; it's the logger a Config without one
+//		gets, so the log stays quiet unless asked not to be
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler     { return h }
+func (h discardHandler) WithGroup(string) slog.Handler          { return h }
+
+//	logger returns the configured logger, or one that discards everything
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(discardHandler{})
+	}
+	return c.Logger
+}
@@ -0,0 +1,148 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+const manifestFile = "manifest.json"
+
+//	manifest is a checkpoint of the log's layout written alongside the
+//		segments. When the previous shutdown was clean the log can trust it
+//		instead of inspecting every segment on startup
+type manifest struct {
+	//	base offsets of every segment, oldest first
+	Segments []uint64 `json:"segments"`
+	//	the offset the next appended record will get
+	HighWatermark uint64 `json:"high_watermark"`
+	//	set only by Close; any other write clears it
+	CleanShutdown bool      `json:"clean_shutdown"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func readManifest(dir string) (*manifest, error) {
+	b, err := os.ReadFile(path.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//	writeManifest replaces the manifest atomically; a crash mid write leaves
+//		the previous version in place
+func writeManifest(dir string, m *manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, manifestFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(dir, manifestFile))
+}
+
+//	manifest builds a checkpoint of the current layout. The caller must hold
+//		the log's lock
+func (l *Log) manifest(clean bool) *manifest {
+	m := &manifest{
+		CleanShutdown: clean,
+		UpdatedAt:     time.Now().UTC(),
+	}
+	for _, s := range l.segments {
+		m.Segments = append(m.Segments, s.baseOffset)
+	}
+	if l.activeSegment != nil {
+		m.HighWatermark = l.activeSegment.nextOffset
+	}
+	return m
+}
+
+//	checkpoint periodically rewrites the manifest so the high watermark on
+//		disk stays close to the real one
+func (l *Log) checkpoint(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			l.mu.RLock()
+			//	Close may have run while we waited for the lock; don't
+			//		overwrite its clean manifest
+			select {
+			case <-done:
+				l.mu.RUnlock()
+				return
+			default:
+			}
+			if err := writeManifest(l.Dir, l.manifest(false)); err != nil {
+				l.Config.Logger.Error("manifest checkpoint failed", "err", err)
+			}
+			l.mu.RUnlock()
+		}
+	}
+}
+
+//	CleanlyClosed reports whether the log in dir was closed properly and is
+//		not currently open
+func CleanlyClosed(dir string) (bool, error) {
+	m, err := readManifest(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.CleanShutdown, nil
+}
+
+//	FitSegments returns c with its segment limits fitted to the log already
+//		in dir, for tools that open a log the server wrote. Opening an index
+//		grows it to MaxIndexBytes and closing it trims it back, so the limit
+//		is raised to the largest existing index, which is then never cut
+//		short. A store limit left 0 takes the largest existing store, so new
+//		segments come out the size the old ones did
+func FitSegments(dir string, c Config) (Config, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return c, err
+	}
+	var store, index uint64
+	for _, file := range files {
+		fi, err := file.Info()
+		if err != nil {
+			return c, err
+		}
+		switch size := uint64(fi.Size()); path.Ext(file.Name()) {
+		case ".store":
+			store = max(store, size)
+		case ".index":
+			index = max(index, size)
+		}
+	}
+	c.Segment.MaxIndexBytes = max(c.Segment.MaxIndexBytes, index)
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = store
+	}
+	return c, nil
+}
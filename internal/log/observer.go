@@ -0,0 +1,42 @@
+package log
+
+//	SegmentInfo describes a segment to observers without handing out the
+//		segment itself
+type SegmentInfo struct {
+	BaseOffset uint64
+	NextOffset uint64
+	StorePath  string
+	IndexPath  string
+	StoreBytes uint64
+	IndexBytes uint64
+}
+
+//	SegmentObserver is implemented by components that need to act on segments
+//		once they stop receiving writes (archivers, mirrors, exporters)
+type SegmentObserver interface {
+	//	SegmentSealed is called when a segment is full and a new active segment
+	//		has taken over. It's called with the log locked so it must not block;
+	//		hand the work off to another goroutine
+	SegmentSealed(info SegmentInfo)
+	//	AllowDelete is asked before a sealed segment is removed. Returning false
+	//		keeps the segment (and every segment after it) until a later attempt
+	AllowDelete(info SegmentInfo) bool
+}
+
+//	SegmentRemovedObserver may also be implemented by a SegmentObserver to hear
+//		about segments after truncation, retention or compaction removed them.
+//		Like SegmentSealed it's called with the log locked
+type SegmentRemovedObserver interface {
+	SegmentRemoved(info SegmentInfo)
+}
+
+func (s *segment) info() SegmentInfo {
+	return SegmentInfo{
+		BaseOffset: s.baseOffset,
+		NextOffset: s.nextOffset,
+		StorePath:  s.store.Name(),
+		IndexPath:  s.index.Name(),
+		StoreBytes: s.store.size,
+		IndexBytes: s.index.size,
+	}
+}
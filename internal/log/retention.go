@@ -0,0 +1,82 @@
+package log
+
+import (
+	"time"
+)
+
+//	enforceRetention applies the retention policy every interval until the log
+//		is closed
+func (l *Log) enforceRetention(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := l.retain(); err != nil {
+				l.Config.Logger.Error("retention failed", "err", err)
+			}
+		}
+	}
+}
+
+//	retain removes the oldest sealed segments that fall outside the retention
+//		policy. Removal goes through Truncate, so observers can still veto it
+//		and the trash still applies. The active segment is never removed
+func (l *Log) retain() error {
+	l.mu.RLock()
+	lowest, ok := l.expired()
+	l.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return l.Truncate(lowest)
+}
+
+//	expired returns the highest offset that retention allows to be dropped,
+//		taking whichever of the age and size limits reaches further. Segments
+//		only ever expire from the oldest end, so each policy stops at the first
+//		segment that has to stay
+func (l *Log) expired() (lowest uint64, ok bool) {
+	drop := func(s *segment) {
+		if !ok || s.nextOffset-1 > lowest {
+			lowest, ok = s.nextOffset-1, true
+		}
+	}
+	//	sealed segments that hold records; the active one is never dropped
+	sealed := func(s *segment) bool {
+		return s != l.activeSegment && s.nextOffset > s.baseOffset
+	}
+
+	if maxAge := l.Config.Retention.MaxAge; maxAge > 0 {
+		cutoff := time.Now().Add(-maxAge).UnixNano()
+		for _, s := range l.segments {
+			if !sealed(s) {
+				break
+			}
+			//	the last offset may have been compacted away, so go by the
+			//		last record there is
+			newest, err := s.last()
+			if err != nil || newest == nil || newest.Timestamp >= cutoff {
+				break
+			}
+			drop(s)
+		}
+	}
+
+	if maxBytes := l.Config.Retention.MaxLogBytes; maxBytes > 0 {
+		var total uint64
+		for _, s := range l.segments {
+			total += s.store.size
+		}
+		for _, s := range l.segments {
+			if total <= maxBytes || !sealed(s) {
+				break
+			}
+			total -= s.store.size
+			drop(s)
+		}
+	}
+	return lowest, ok
+}
@@ -1,12 +1,14 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"time"
 
 	api "github.com/NathanClassen/hydralog/api/v1"
-	"google.golang.org/protobuf/proto"
 )
 
 //	Segement is an abstraction over a store and an index
@@ -22,10 +24,23 @@ type segment struct {
 	index *index
 	baseOffset, nextOffset uint64
 	config Config
+	//	timestamps of the records, for finding where a point in time starts;
+	//		the newest timestamp any record has, and where the last time
+	//		entry was written in the store
+	timeIndex    *timeIndex
+	maxTimestamp int64
+	timePos      uint64
 }
 
 //	Return a pointer to a segement
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	return openSegment(dir, baseOffset, c, false)
+}
+
+//	openSegment opens a segment; clean says the log was closed cleanly, so
+//		the caller knows where it ends and the store's tail needn't be read
+//		to find out
+func openSegment(dir string, baseOffset uint64, c Config, clean bool) (*segment, error) {
 	//	Create segement; records will begin at baseOffset record
 	s := &segment{
 		baseOffset: baseOffset,
@@ -43,7 +58,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 	//	create store out of store file
-	if s.store, err = newStore(storeFile); err != nil {
+	if s.store, err = newStore(storeFile, c); err != nil {
 		return nil, err
 	}
 
@@ -72,6 +87,36 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		//	if so, the nextOffset is the base + the latest offset + 1
 		s.nextOffset = baseOffset + uint64(off) + 1
 	}
+	//	with sparse indexing the newest records aren't in the index, so read
+	//		on through the store to find them. If the store doesn't match the
+	//		index the integrity check sorts it out
+	if !clean {
+		if _, err := s.walk(func(offset, pos uint64) bool {
+			s.nextOffset = offset + 1
+			return true
+		}); errors.Is(err, errKey) {
+			return nil, err
+		}
+	}
+
+	timeFile, err := os.OpenFile(
+		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".timeindex")),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.timeIndex, err = newTimeIndex(timeFile); err != nil {
+		return nil, err
+	}
+	//	a clean close leaves the time index complete, unless the segment was
+	//		written before there was one
+	if _, ok := s.timeIndex.last(); !clean || !ok {
+		if err = s.reindexTimes(); err != nil {
+			return nil, err
+		}
+	}
 
 	return s, nil
 }
@@ -80,8 +125,11 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	// obtain next offset for segment and set on record
 	cur := s.nextOffset
 	record.Offset = cur
+	if record.Timestamp == 0 {
+		record.Timestamp = time.Now().UnixNano()
+	}
 	//	marshall record into pb
-	p, err := proto.Marshal(record)
+	p, err := s.encode(record)
 	if err != nil {
 		return 0, err
 	}
@@ -92,10 +140,10 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 		return 0, err
 	}
 	//	write the index for the record
-	if err = s.index.Write(
-		uint32(s.nextOffset-uint64(s.baseOffset)),
-		pos,
-	); err != nil {
+	if err = s.indexRecord(s.nextOffset, pos); err != nil {
+		return 0, err
+	}
+	if err = s.indexTime(s.nextOffset, pos, record.Timestamp); err != nil {
 		return 0, err
 	}
 	//	update the next offset on the segment
@@ -103,24 +151,260 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	return cur, nil
 }
 
-func (s *segment) Read(offset uint64) (*api.Record, error) {
-	_, pos, err := s.index.Read(int64(offset - s.baseOffset))
+//	AppendBatch appends as many of records as the segment has room for with a
+//		single store write and flush, and returns their offsets. Like Append,
+//		the record that takes the store over its limit is still accepted
+func (s *segment) AppendBatch(records []*api.Record) ([]uint64, error) {
+	//	entries the index can still take
+	room := (uint64(len(s.index.mmap)) - s.index.size) / entWidth
+	size := s.store.size
+
+	now := time.Now().UnixNano()
+	var batch [][]byte
+	for _, record := range records {
+		if uint64(len(batch)) == room || size >= s.config.Segment.MaxStoreBytes {
+			break
+		}
+		record.Offset = s.nextOffset + uint64(len(batch))
+		if record.Timestamp == 0 {
+			record.Timestamp = now
+		}
+		p, err := s.encode(record)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, p)
+		size += uint64(len(p)) + headerWidth
+	}
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+
+	positions, err := s.store.AppendBatch(batch)
 	if err != nil {
 		return nil, err
 	}
+	offsets := make([]uint64, len(positions))
+	for i, pos := range positions {
+		if err = s.indexRecord(s.nextOffset, pos); err != nil {
+			return nil, err
+		}
+		if err = s.indexTime(s.nextOffset, pos, records[i].Timestamp); err != nil {
+			return nil, err
+		}
+		offsets[i] = s.nextOffset
+		s.nextOffset++
+	}
+	return offsets, nil
+}
+
+//	Read returns the record at offset or, if compaction removed it, the next
+//		record the segment still has. io.EOF means there's none
+func (s *segment) Read(offset uint64) (*api.Record, error) {
+	record, _, err := s.find(offset)
+	return record, err
+}
 
-	p, err := s.store.Read(pos)
+//	find returns the first record at or after offset and its position in the
+//		store. The index leads to the nearest record before it, which with
+//		dense indexing and no compaction is the record itself; otherwise the
+//		store is scanned from there
+func (s *segment) find(offset uint64) (*api.Record, uint64, error) {
+	_, pos, err := s.index.Lookup(uint32(offset - s.baseOffset))
+	if err != nil {
+		return nil, 0, err
+	}
+	for pos < s.store.size {
+		record, n, ok := s.config.cache.get(s, pos)
+		if !ok {
+			p, err := s.store.Read(pos)
+			if err == errChecksum {
+				return nil, 0, api.ErrCorruptRecord{Offset: offset}
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if record, err = s.decode(p); err != nil {
+				return nil, 0, err
+			}
+			n = uint64(len(p))
+			if encoded(p) {
+				s.config.cache.put(s, pos, record, n)
+			}
+		}
+		if record.Offset >= offset {
+			return record, pos, nil
+		}
+		pos += headerWidth + n
+	}
+	return nil, 0, io.EOF
+}
+
+//	indexRecord writes the index entry for the record at pos, unless sparse
+//		indexing leaves it to be found by scanning from the previous entry
+func (s *segment) indexRecord(offset, pos uint64) error {
+	if interval := s.config.Segment.IndexIntervalBytes; interval > 0 {
+		if _, last, err := s.index.Read(-1); err == nil && pos-last < interval {
+			return nil
+		}
+	}
+	return s.index.Write(uint32(offset-s.baseOffset), pos)
+}
+
+//	indexTime writes a time index entry for the record at pos when it's newer
+//		than every record before it. With sparse indexing entries are spaced
+//		out the same way the offset index's are
+func (s *segment) indexTime(offset, pos uint64, timestamp int64) error {
+	if timestamp <= s.maxTimestamp {
+		return nil
+	}
+	s.maxTimestamp = timestamp
+	if interval := s.config.Segment.IndexIntervalBytes; interval > 0 {
+		if _, ok := s.timeIndex.last(); ok && pos-s.timePos < interval {
+			return nil
+		}
+	}
+	s.timePos = pos
+	return s.timeIndex.Write(timeEntry{
+		timestamp: timestamp,
+		offset:    uint32(offset - s.baseOffset),
+	})
+}
+
+//	reindexTimes runs the records after the last time index entry through
+//		indexTime again: entries that were lost in a crash, or all of them
+//		for a segment written before there was a time index. It stops at the
+//		first record it can't read
+func (s *segment) reindexTimes() error {
+	from := s.baseOffset
+	if e, ok := s.timeIndex.last(); ok {
+		s.maxTimestamp = e.timestamp
+		from += uint64(e.offset) + 1
+	}
+	var pos uint64
+	if from > s.baseOffset {
+		_, p, err := s.find(from)
+		if err != nil {
+			return nil
+		}
+		pos = p
+	}
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return nil
+		}
+		record, err := s.decode(p)
+		if errors.Is(err, errKey) {
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+		if err := s.indexTime(record.Offset, pos, record.Timestamp); err != nil {
+			return err
+		}
+		pos += headerWidth + uint64(len(p))
+	}
+	return nil
+}
+
+//	offsetForTime returns the offset of the segment's first record with a
+//		timestamp at or after timestamp; io.EOF means every record is older
+func (s *segment) offsetForTime(timestamp int64) (uint64, error) {
+	next := s.baseOffset + uint64(s.timeIndex.start(timestamp))
+	_, pos, err := s.find(next)
+	if err != nil {
+		return 0, err
+	}
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err == errChecksum {
+			return 0, api.ErrCorruptRecord{Offset: next}
+		}
+		if err != nil {
+			return 0, err
+		}
+		record, err := s.decode(p)
+		if err != nil {
+			return 0, err
+		}
+		if record.Timestamp >= timestamp {
+			return record.Offset, nil
+		}
+		next = record.Offset + 1
+		pos += headerWidth + uint64(len(p))
+	}
+	return 0, io.EOF
+}
+
+//	walk reads through the store after the last indexed record, calling fn
+//		with the offset and position of each whole record until fn returns
+//		false. It stops at the first record that's torn, fails its checksum
+//		or isn't the next offset, and returns the position it stopped at. A
+//		record it has no key for is an error rather than a stop
+func (s *segment) walk(fn func(offset, pos uint64) bool) (uint64, error) {
+	var pos uint64
+	next := s.baseOffset
+	if s.index.size > 0 {
+		off, last, err := s.index.Read(-1)
+		if err != nil {
+			return 0, err
+		}
+		p, err := s.store.Read(last)
+		if err != nil {
+			return 0, err
+		}
+		pos = last + headerWidth + uint64(len(p))
+		next = s.baseOffset + uint64(off) + 1
+	}
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			break
+		}
+		record, err := s.decode(p)
+		if errors.Is(err, errKey) {
+			return pos, err
+		}
+		if err != nil || record.Offset != next {
+			break
+		}
+		if !fn(next, pos) {
+			break
+		}
+		next++
+		pos += headerWidth + uint64(len(p))
+	}
+	return pos, nil
+}
+
+//	last returns the segment's newest record
+func (s *segment) last() (*api.Record, error) {
+	_, pos, err := s.index.Read(-1)
 	if err != nil {
 		return nil, err
 	}
-	record := &api.Record{}
-	err = proto.Unmarshal(p, record)
-	return record, err
+	var record *api.Record
+	for pos < s.store.size {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return nil, err
+		}
+		if record, err = s.decode(p); err != nil {
+			return nil, err
+		}
+		pos += headerWidth + uint64(len(p))
+	}
+	return record, nil
 }
 
+//	IsMaxed reports whether the segment is full: its store has reached its
+//		limit, or its index can't take another entry. The index limit needn't
+//		be a multiple of the entry width
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes ||
-		s.index.size >= s.config.Segment.MaxIndexBytes
+		s.index.size+entWidth > s.config.Segment.MaxIndexBytes
 }
 
 func (s *segment) Remove() error {
@@ -136,16 +420,24 @@ func (s *segment) Remove() error {
 		return err
 	}
 
-	return nil
+	if err := os.Remove(s.timeIndex.Name()); err != nil {
+		return err
+	}
+
+	return s.store.removeScratch()
 }
 
 func (s *segment) Close() error {
+	s.config.cache.purge(s)
 	if err := s.index.Close(); err != nil {
 		return err
 	}
 	if err := s.store.Close(); err != nil {
 		return err
 	}
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
 
 	return nil
 }
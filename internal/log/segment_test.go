@@ -52,4 +52,17 @@ func TestSegment(t *testing.T) {
 	s, err = newSegment(dir, 16, c)
 	require.NoError(t, err)
 	require.False(t, s.IsMaxed())
+
+	// an index limit that isn't a whole number of entries is full once
+	// the next entry won't fit
+	require.NoError(t, s.Remove())
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = entWidth*3 + 4
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.Append(want)
+		require.NoError(t, err)
+	}
+	require.True(t, s.IsMaxed())
 }
\ No newline at end of file
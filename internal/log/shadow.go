@@ -0,0 +1,50 @@
+package log
+
+import (
+	"math/rand"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+//	shadowRead re-reads a sample of reads by walking the store from the start
+//		of the segment, without the index, and counts the reads where the two
+//		disagree. A mismatch means the index points at the wrong record
+func (l *Log) shadowRead(s *segment, offset uint64, record *api.Record) {
+	rate := l.Config.ShadowRead.SampleRate
+	if rate <= 0 || rand.Float64() >= rate {
+		return
+	}
+	l.shadowReads.Add(1)
+	shadow, err := s.scanTo(offset)
+	if err != nil || !proto.Equal(shadow, record) {
+		l.shadowMismatches.Add(1)
+		l.Config.Logger.Error("shadow read mismatch", "offset", offset, "base_offset", s.baseOffset)
+	}
+}
+
+//	ShadowReads returns how many reads were verified and how many of those
+//		didn't match
+func (l *Log) ShadowReads() (reads, mismatches uint64) {
+	return l.shadowReads.Load(), l.shadowMismatches.Load()
+}
+
+//	scanTo decodes the store entry by entry and returns the first record at
+//		or after offset, the same one the index should lead to
+func (s *segment) scanTo(offset uint64) (*api.Record, error) {
+	for pos := uint64(0); pos < s.store.size; {
+		p, err := s.store.Read(pos)
+		if err != nil {
+			return nil, err
+		}
+		record, err := s.decode(p)
+		if err != nil {
+			return nil, err
+		}
+		if record.Offset >= offset {
+			return record, nil
+		}
+		pos += headerWidth + uint64(len(p))
+	}
+	return nil, api.ErrOffsetOutOfRange{Offset: offset}
+}
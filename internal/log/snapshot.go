@@ -0,0 +1,89 @@
+package log
+
+import (
+	"io"
+	"os"
+)
+
+//	snapshotSegments notes where each of the log's segments ends now and
+//		holds its files open, so they can be copied that far after the lock
+//		is let go. The caller closes them; topic is what they're filed under
+func (l *Log) snapshotSegments(topic string) ([]snapshotSegment, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	snap := &snapshot{}
+	for _, s := range l.segments {
+		if err := s.store.Flush(); err != nil {
+			snap.Release()
+			return nil, err
+		}
+		//	holding the files open keeps the data around even if
+		//		retention or compaction replaces them before they're copied
+		store, err := os.Open(s.store.Name())
+		if err != nil {
+			snap.Release()
+			return nil, err
+		}
+		index, err := os.Open(s.index.Name())
+		if err != nil {
+			store.Close()
+			snap.Release()
+			return nil, err
+		}
+		snap.segments = append(snap.segments, snapshotSegment{
+			topic:      topic,
+			baseOffset: s.baseOffset,
+			store:      store,
+			storeSize:  s.store.size,
+			index:      index,
+			indexSize:  s.index.size,
+		})
+	}
+	return snap.segments, nil
+}
+
+//	Snapshot writes every segment to w, with its base offset, in the format
+//		raft snapshots use. Appends carry on while it's written; it holds
+//		what was there when it started
+func (l *Log) Snapshot(w io.Writer) error {
+	segments, err := l.snapshotSegments("")
+	if err != nil {
+		return err
+	}
+	snap := &snapshot{segments: segments}
+	defer snap.Release()
+	for _, seg := range segments {
+		if err := seg.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Restore replaces everything in the log with a snapshot Snapshot wrote,
+//		rebuilding the log's directory from it and opening its segments like
+//		any others. The log must not be in use while it runs; if it fails
+//		the log is left closed
+func (l *Log) Restore(r io.Reader) error {
+	if err := l.Remove(); err != nil {
+		return err
+	}
+	l.segments, l.activeSegment = nil, nil
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	for {
+		var seg snapshotSegment
+		err := seg.readHeader(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := seg.readFiles(r, l.Dir); err != nil {
+			return err
+		}
+	}
+	return l.setup()
+}
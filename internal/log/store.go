@@ -3,7 +3,11 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -13,17 +17,37 @@ var enc = binary.BigEndian
 //		length of the record each time a new record is written
 const lenWidth = 8
 
+//	after the length comes a CRC32C of the record so a torn or bit-flipped
+//		record is caught on read rather than handed to consumers
+const crcWidth = 4
+
+//	every entry is the length, the checksum, and then the record itself
+const headerWidth = lenWidth + crcWidth
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//	returned by Read when a record doesn't match its checksum
+var errChecksum = errors.New("log: record checksum mismatch")
+
 type store struct {
 	File *os.File
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	//	when to fsync, and appends since the last one
+	policy   SyncPolicy
+	unsynced uint64
+	//	with double-writing, the block size and the scratch file the last
+	//		partial block goes to, and whether opening had to restore it
+	block    uint64
+	scratch  *os.File
+	restored bool
 }
 
 // creates a new store from file, getting the size of the store
 //
 //	via os.Stat, and setting a writer for the file
-func newStore(f *os.File) (*store, error) {
+func newStore(f *os.File, c Config) (*store, error) {
 	fi, err := os.Stat(f.Name())
 	if err != nil {
 		return nil, err
@@ -31,11 +55,22 @@ func newStore(f *os.File) (*store, error) {
 
 	size := uint64(fi.Size())
 
-	return &store{
-		File: f,
-		size: size,
-		buf:  bufio.NewWriter(f),
-	}, nil
+	s := &store{
+		File:   f,
+		size:   size,
+		buf:    bufio.NewWriter(f),
+		policy: c.Store.SyncPolicy,
+		block:  c.Store.DoubleWriteBlockBytes,
+	}
+	if s.block > 0 {
+		if s.scratch, err = os.OpenFile(scratchName(f.Name()), os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return nil, err
+		}
+		if err = s.restoreTail(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 //	writes a new record to the store. Writes to the buffered writer
@@ -55,31 +90,214 @@ func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	//		length of the record to be written-this will allow us
 	//		to read precisely the correct number of bytes when
 	//		reading the record
-	//	this length is written in binary encording
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
-		return 0, 0, err
-	}
-
-	//	write the content of the record and return the number of 
-	//		bytes written, i.e. the length of the record
-	w, err := s.buf.Write(p)
+	//	this length is written in binary encording, followed by the
+	//		checksum of the record
+	w, err := s.write(p)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	//	length of record just written + number of bytes used to 
-	//		record the records length. This is the length of one
-	//		complete entry...
-	w += lenWidth
-
-	//	...ergo, the size of the store is now increased by `w`
+	//	w is the length of one complete entry, so the size of the
+	//		store is now increased by `w`
 	s.size += uint64(w)
 
+	//	make the record durable now if the sync policy asks for it
+	if err := s.wrote(1); err != nil {
+		return 0, 0, err
+	}
+
 	//	return the length of the entry just made and the position
 	//		of the entry in the store
 	return uint64(w), pos, nil
 }
 
+//	writes one entry (length, checksum, record) to the buffer and returns
+//		the number of bytes it takes up. The caller must hold the lock
+func (s *store) write(p []byte) (int, error) {
+	header := make([]byte, headerWidth)
+	enc.PutUint64(header[:lenWidth], uint64(len(p)))
+	enc.PutUint32(header[lenWidth:], crc32.Checksum(p, crcTable))
+	if _, err := s.buf.Write(header); err != nil {
+		return 0, err
+	}
+	w, err := s.buf.Write(p)
+	if err != nil {
+		return 0, err
+	}
+	return w + headerWidth, nil
+}
+
+//	writes several records with one lock acquisition and flushes them to the
+//		file together, returning the position of each
+func (s *store) AppendBatch(ps [][]byte) (positions []uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions = make([]uint64, len(ps))
+	for i, p := range ps {
+		positions[i] = s.size
+		w, err := s.write(p)
+		if err != nil {
+			return nil, err
+		}
+		s.size += uint64(w)
+	}
+	if err := s.buf.Flush(); err != nil {
+		return nil, err
+	}
+	//	the batch counts as one write per record but gets a single fsync
+	if err := s.wrote(uint64(len(ps))); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+//	wrote counts n appended records against the sync policy and syncs when
+//		it's due. The caller must hold the lock
+func (s *store) wrote(n uint64) error {
+	s.unsynced += n
+	switch s.policy.Mode {
+	case SyncAlways:
+		return s.sync()
+	case SyncEveryNWrites:
+		if s.unsynced >= s.policy.N {
+			return s.sync()
+		}
+	}
+	return nil
+}
+
+//	sync flushes the buffer and fsyncs the file. The caller must hold the lock
+func (s *store) sync() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return s.saveTail()
+}
+
+//	a scratch file holds where its copy of the store starts, how long it is
+//		and its checksum, then the copy itself
+const scratchHeaderWidth = 2*lenWidth + crcWidth
+
+func scratchName(storeName string) string {
+	return strings.TrimSuffix(storeName, ".store") + ".doublewrite"
+}
+
+//	saveTail copies the store's last partial block to the scratch file, right
+//		after an fsync and before anything else is appended into that block,
+//		so the synced records in it survive the block being torn when it's
+//		next written. A torn copy just fails its checksum: the store's block
+//		can't be torn while the copy is being made. The caller must hold the
+//		lock
+func (s *store) saveTail() error {
+	if s.block == 0 {
+		return nil
+	}
+	start := s.size / s.block * s.block
+	b := make([]byte, scratchHeaderWidth+s.size-start)
+	if _, err := s.File.ReadAt(b[scratchHeaderWidth:], int64(start)); err != nil {
+		return err
+	}
+	enc.PutUint64(b[:lenWidth], start)
+	enc.PutUint64(b[lenWidth:2*lenWidth], s.size-start)
+	enc.PutUint32(b[2*lenWidth:scratchHeaderWidth], crc32.Checksum(b[scratchHeaderWidth:], crcTable))
+	if _, err := s.scratch.WriteAt(b, 0); err != nil {
+		return err
+	}
+	if err := s.scratch.Truncate(int64(len(b))); err != nil {
+		return err
+	}
+	return s.scratch.Sync()
+}
+
+//	restoreTail puts the scratch file's copy of the last synced block back
+//		into the store if the store's doesn't match it. Appends never change
+//		bytes that were already written, so the copy is always right for
+//		the range it covers
+func (s *store) restoreTail() error {
+	b, err := io.ReadAll(s.scratch)
+	if err != nil || len(b) < scratchHeaderWidth {
+		return err
+	}
+	start := enc.Uint64(b[:lenWidth])
+	data := b[scratchHeaderWidth:]
+	if enc.Uint64(b[lenWidth:2*lenWidth]) != uint64(len(data)) ||
+		crc32.Checksum(data, crcTable) != enc.Uint32(b[2*lenWidth:scratchHeaderWidth]) {
+		return nil
+	}
+	have := make([]byte, len(data))
+	n, _ := s.File.ReadAt(have, int64(start))
+	if n == len(data) && string(have) == string(data) {
+		return nil
+	}
+	//	the store is opened for appending, which WriteAt can't do
+	w, err := os.OpenFile(s.File.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = w.WriteAt(data, int64(start)); err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if end := start + uint64(len(data)); end > s.size {
+		s.size = end
+	}
+	s.restored = true
+	return nil
+}
+
+//	removeScratch deletes the scratch file of a store that's closed for good,
+//		so a segment created later with the same base offset doesn't pick it up
+func (s *store) removeScratch() error {
+	if s.scratch == nil {
+		return nil
+	}
+	if err := os.Remove(s.scratch.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//	Truncate cuts the store down to size bytes
+func (s *store) Truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	//	the scratch copy may cover bytes that are gone now
+	if s.block == 0 {
+		return nil
+	}
+	if err := s.File.Sync(); err != nil {
+		return err
+	}
+	return s.saveTail()
+}
+
+//	Sync makes every appended record durable
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unsynced == 0 {
+		return nil
+	}
+	return s.sync()
+}
+
 //	reads a record from the store
 func (s *store) Read(pos uint64) ([]byte, error) {
 	s.mu.Lock()
@@ -96,25 +314,37 @@ func (s *store) Read(pos uint64) ([]byte, error) {
 	//		begins with a number entry telling us how long the actual
 	//		record is and thus how many bytes need to be read. So we
 	//		create a slice to hold that number entry-it's of len `lenWidth`
-	//		because that's how many bytes we use to store the record len
-	size := make([]byte, lenWidth)
-	//	read in the length entry
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	//		because that's how many bytes we use to store the record len.
+	//		The checksum comes right after it, so read both
+	header := make([]byte, headerWidth)
+	//	read in the length and checksum entries
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
 		return nil, err
 	}
 
+	//	a torn or garbage header can't be trusted to size the buffer. The
+	//		comparison is arranged so a huge size can't wrap it around
+	size := enc.Uint64(header[:lenWidth])
+	if pos+headerWidth > s.size || size > s.size-pos-headerWidth {
+		return nil, io.ErrUnexpectedEOF
+	}
+
 	//	now that we know the length of the record, create a slice to 
 	//		hold it
-	b := make([]byte, enc.Uint64(size))
+	b := make([]byte, size)
 
 	//	read the record of length len(b) into b. We start reading at
-	//		pos+lenWidth because pos is where the record entry begins;
-	//		it begins with a length indicator of length lenWidth. So the
-	//		record itself begins at pos+lenWidth
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	//		pos+headerWidth because pos is where the record entry begins;
+	//		it begins with a length indicator and a checksum. So the
+	//		record itself begins at pos+headerWidth
+	if _, err := s.File.ReadAt(b, int64(pos+headerWidth)); err != nil {
 		return nil, err
 	}
 
+	if crc32.Checksum(b, crcTable) != enc.Uint32(header[lenWidth:]) {
+		return nil, errChecksum
+	}
+
 	//	return the record
 	return b, nil
 }
@@ -131,6 +361,13 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+//	write any buffered data to the file
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Flush()
+}
+
 //	persist any buffered data and then close the store file
 func (s *store) Close() error {
 	s.mu.Lock()
@@ -139,6 +376,11 @@ func (s *store) Close() error {
 	if err != nil {
 		return err
 	}
+	if s.scratch != nil {
+		if err := s.scratch.Close(); err != nil {
+			return err
+		}
+	}
 	return s.File.Close()
 }
 
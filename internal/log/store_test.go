@@ -1,6 +1,8 @@
 package log
 
 import (
+	"io"
+	"math"
 	"os"
 	"testing"
 
@@ -9,7 +11,7 @@ import (
 
 var (
 	write = []byte("hello, world")
-	width = uint64(len(write)) + lenWidth
+	width = uint64(len(write)) + headerWidth
 )
 
 func TestStoreAppendRead(t *testing.T) {
@@ -17,7 +19,7 @@ func TestStoreAppendRead(t *testing.T) {
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
 
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 
 	require.NoError(t, err)
 
@@ -25,7 +27,7 @@ func TestStoreAppendRead(t *testing.T) {
 	testRead(t, s)
 	testReadAt(t, s)
 
-	s, err = newStore(f)
+	s, err = newStore(f, Config{})
 
 	require.NoError(t, err)
 
@@ -56,13 +58,13 @@ func testRead(t *testing.T, s *store) { t.Helper()
 func testReadAt(t *testing.T, s *store) {
 	t.Helper()
 	for i, off := uint64(1), int64(0); i < 4; i++ {
-		b := make([]byte, lenWidth)
+		b := make([]byte, headerWidth)
 		n, err := s.ReadAt(b, off)
 		require.NoError(t, err)
-		require.Equal(t, lenWidth, n)
+		require.Equal(t, headerWidth, n)
 		off += int64(n)
 
-		size := enc.Uint64(b)
+		size := enc.Uint64(b[:lenWidth])
 		b = make([]byte, size)
 		n, err = s.ReadAt(b, off)
 		require.NoError(t, err)
@@ -72,11 +74,92 @@ func testReadAt(t *testing.T, s *store) {
 	}
 }
 
+func TestStoreChecksum(t *testing.T) {
+	f, err := os.CreateTemp("", "store_checksum_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, s.Flush())
+
+	// flip a bit in the middle of the record
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, int64(pos+headerWidth+2))
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	_, err = f.WriteAt(b, int64(pos+headerWidth+2))
+	require.NoError(t, err)
+
+	_, err = s.Read(pos)
+	require.Equal(t, errChecksum, err)
+}
+
+func TestStoreGarbageLength(t *testing.T) {
+	f, err := os.CreateTemp("", "store_length_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f, Config{})
+	require.NoError(t, err)
+	_, pos, err := s.Append(write)
+	require.NoError(t, err)
+	require.NoError(t, s.Flush())
+
+	// a length so big that adding it to the position wraps around
+	b := make([]byte, lenWidth)
+	enc.PutUint64(b, math.MaxUint64-headerWidth+1)
+	_, err = f.WriteAt(b, int64(pos))
+	require.NoError(t, err)
+
+	_, err = s.Read(pos)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	// and a position past the end doesn't wrap either
+	_, err = s.Read(s.size - 1)
+	require.Error(t, err)
+}
+
+func TestStoreSyncPolicy(t *testing.T) {
+	f, err := os.CreateTemp("", "store_sync_policy_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Store.SyncPolicy = SyncPolicy{Mode: SyncEveryNWrites, N: 2}
+	s, err := newStore(f, c)
+	require.NoError(t, err)
+
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	_, size, err := openFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+
+	// the second write is flushed to the file along with the first
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	_, size, err = openFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(2*width), size)
+	require.Equal(t, uint64(0), s.unsynced)
+
+	c.Store.SyncPolicy = SyncPolicy{Mode: SyncAlways}
+	s, err = newStore(f, c)
+	require.NoError(t, err)
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	_, size, err = openFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(3*width), size)
+}
+
 func TestStoreClose(t *testing.T) {
 	f, err := os.CreateTemp("", "store_close_test")
 	require.NoError(t, err)
 	defer os.Remove(f.Name())
-	s, err := newStore(f)
+	s, err := newStore(f, Config{})
 	require.NoError(t, err)
 	_, _, err = s.Append(write)
 	require.NoError(t, err)
@@ -103,4 +186,60 @@ func openFile(name string) (file *os.File, size int64, err error) {
 		return nil, 0, err
 	}
 	return f, fi.Size(), nil
-}
\ No newline at end of file
+}
+
+func TestStoreDoubleWrite(t *testing.T) {
+	f, err := os.CreateTemp("", "store_double_write_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer os.Remove(scratchName(f.Name()))
+
+	c := Config{}
+	c.Store.SyncPolicy = SyncPolicy{Mode: SyncAlways}
+	c.Store.DoubleWriteBlockBytes = 16
+	s, err := newStore(f, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _, err = s.Append(write)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	// an intact store is left alone
+	f, _, err = openFile(f.Name())
+	require.NoError(t, err)
+	s, err = newStore(f, c)
+	require.NoError(t, err)
+	require.False(t, s.restored)
+	require.NoError(t, s.Close())
+
+	// tear the last block, which the third record ends in
+	tail := 3 * width / 16 * 16
+	require.NoError(t, os.Truncate(f.Name(), int64(tail+2)))
+	torn, err := os.OpenFile(f.Name(), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = torn.WriteAt([]byte{0xff, 0xff}, int64(tail))
+	require.NoError(t, err)
+	require.NoError(t, torn.Close())
+	f, _, err = openFile(f.Name())
+	require.NoError(t, err)
+
+	s, err = newStore(f, c)
+	require.NoError(t, err)
+	require.True(t, s.restored)
+	require.Equal(t, 3*width, s.size)
+	read, err := s.Read(2 * width)
+	require.NoError(t, err)
+	require.Equal(t, write, read)
+
+	// truncating moves the copy along with the end of the store
+	require.NoError(t, s.Truncate(width))
+	require.NoError(t, s.Close())
+	f, _, err = openFile(f.Name())
+	require.NoError(t, err)
+	s, err = newStore(f, c)
+	require.NoError(t, err)
+	require.False(t, s.restored)
+	require.Equal(t, width, s.size)
+	require.NoError(t, s.Close())
+}
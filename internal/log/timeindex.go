@@ -0,0 +1,114 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+var (
+	tsWidth   uint64 = 8
+	timeWidth        = tsWidth + offWidth
+)
+
+type timeEntry struct {
+	timestamp int64
+	offset    uint32
+}
+
+//	timeIndex maps timestamps to relative offsets. An entry is only written
+//		for a record newer than every record before it in the segment, so an
+//		entry's timestamp is the newest of all the records up to its offset.
+//		Entries are few enough to keep in memory; the file is only ever
+//		appended to, and anything it lost in a crash is indexed again on open
+type timeIndex struct {
+	file    *os.File
+	entries []timeEntry
+}
+
+func newTimeIndex(f *os.File) (*timeIndex, error) {
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	t := &timeIndex{file: f}
+	for at := uint64(0); at+timeWidth <= uint64(len(b)); at += timeWidth {
+		e := timeEntry{
+			timestamp: int64(enc.Uint64(b[at : at+tsWidth])),
+			offset:    enc.Uint32(b[at+tsWidth : at+timeWidth]),
+		}
+		//	entries go up in both time and offset; one that doesn't is left
+		//		over from a torn write
+		if n := len(t.entries); n > 0 &&
+			(e.timestamp <= t.entries[n-1].timestamp || e.offset <= t.entries[n-1].offset) {
+			break
+		}
+		t.entries = append(t.entries, e)
+	}
+	if size := t.size(); size != uint64(len(b)) {
+		if err := f.Truncate(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+//	Write appends an entry
+func (t *timeIndex) Write(e timeEntry) error {
+	b := make([]byte, timeWidth)
+	enc.PutUint64(b[:tsWidth], uint64(e.timestamp))
+	enc.PutUint32(b[tsWidth:], e.offset)
+	if _, err := t.file.Write(b); err != nil {
+		return err
+	}
+	t.entries = append(t.entries, e)
+	return nil
+}
+
+//	start returns the relative offset a search for the first record at or
+//		after timestamp starts from: just past the last entry older than it,
+//		since every record up to that entry is older too
+func (t *timeIndex) start(timestamp int64) uint32 {
+	n := sort.Search(len(t.entries), func(k int) bool {
+		return t.entries[k].timestamp >= timestamp
+	})
+	if n == 0 {
+		return 0
+	}
+	return t.entries[n-1].offset + 1
+}
+
+//	last returns the newest entry, if there is one
+func (t *timeIndex) last() (timeEntry, bool) {
+	if len(t.entries) == 0 {
+		return timeEntry{}, false
+	}
+	return t.entries[len(t.entries)-1], true
+}
+
+//	truncate drops the entries for relative offsets from offset on
+func (t *timeIndex) truncate(offset uint32) error {
+	n := sort.Search(len(t.entries), func(k int) bool {
+		return t.entries[k].offset >= offset
+	})
+	if n == len(t.entries) {
+		return nil
+	}
+	t.entries = t.entries[:n]
+	return t.file.Truncate(int64(t.size()))
+}
+
+func (t *timeIndex) size() uint64 {
+	return uint64(len(t.entries)) * timeWidth
+}
+
+func (t *timeIndex) Close() error {
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	return t.file.Close()
+}
+
+func (t *timeIndex) Name() string {
+	return t.file.Name()
+}
@@ -0,0 +1,51 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeIndex(t *testing.T) {
+	f, err := os.CreateTemp(os.TempDir(), "timeindex_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	idx, err := newTimeIndex(f)
+	require.NoError(t, err)
+	_, ok := idx.last()
+	require.False(t, ok)
+	require.Equal(t, uint32(0), idx.start(100))
+
+	for _, e := range []timeEntry{{10, 0}, {20, 3}, {30, 5}} {
+		require.NoError(t, idx.Write(e))
+	}
+	require.Equal(t, uint32(0), idx.start(5))
+	require.Equal(t, uint32(0), idx.start(10))
+	require.Equal(t, uint32(1), idx.start(15))
+	require.Equal(t, uint32(6), idx.start(31))
+	require.NoError(t, idx.Close())
+
+	// a torn entry at the end is dropped on open
+	f, err = os.OpenFile(f.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+	idx, err = newTimeIndex(f)
+	require.NoError(t, err)
+	require.Len(t, idx.entries, 3)
+	require.Equal(t, idx.size(), uint64(3*timeWidth))
+
+	require.NoError(t, idx.truncate(3))
+	require.Len(t, idx.entries, 1)
+	e, ok := idx.last()
+	require.True(t, ok)
+	require.Equal(t, timeEntry{10, 0}, e)
+	require.NoError(t, idx.Close())
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(timeWidth), fi.Size())
+}
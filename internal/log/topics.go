@@ -0,0 +1,349 @@
+package log
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	records produced without a topic go here
+const DefaultTopic = "default"
+
+//	topic names double as directory names, so keep them to a safe alphabet.
+//		They can't start with a dot so they never clash with .trash and friends
+var validTopic = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]{0,248}$`)
+
+//	ValidTopic reports whether records can be appended to a topic called name
+func ValidTopic(name string) bool {
+	return name != EventsTopic && validTopic.MatchString(name)
+}
+
+//	Topics manages one Log per topic, each in its own directory under Dir.
+//		Topics are created the first time something is appended to them
+type Topics struct {
+	mu sync.RWMutex
+
+	Dir    string
+	Config Config
+
+	logs    map[string]*Log
+	journal *journal
+	//	the EventsTopic log when events are enabled
+	events *Log
+}
+
+func NewTopics(dir string, c Config) (*Topics, error) {
+	if c.cache == nil && c.Store.DecodeCacheBytes > 0 {
+		c.cache = newRecordCache(c.Store.DecodeCacheBytes)
+	}
+	t := &Topics{
+		Dir:    dir,
+		Config: c,
+		logs:   make(map[string]*Log),
+	}
+	return t, t.setup()
+}
+
+//	setup finishes any topic operation a crash interrupted, throws away any
+//		clone it cut short, and then opens a Log for every topic directory in
+//		Dir
+func (t *Topics) setup() error {
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+	j, pending, err := openJournal(t.Dir)
+	if err != nil {
+		return err
+	}
+	t.journal = j
+	for _, e := range pending {
+		if err := t.apply(e); err != nil {
+			return err
+		}
+	}
+	//	everything in the journal has been applied now
+	if err := t.journal.reset(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(t.Dir, cloneDir)); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(t.Dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() || !validTopic.MatchString(file.Name()) {
+			continue
+		}
+		l, err := NewLog(path.Join(t.Dir, file.Name()), t.topicConfig(file.Name()))
+		if err != nil {
+			return err
+		}
+		t.observe(file.Name(), l)
+		t.logs[file.Name()] = l
+	}
+	if t.Config.Events.Enabled {
+		if t.events, err = t.Topic(EventsTopic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	Topic returns the log for name, creating the topic if it doesn't exist
+func (t *Topics) Topic(name string) (*Log, error) {
+	if name == "" {
+		name = DefaultTopic
+	}
+	t.mu.RLock()
+	l, ok := t.logs[name]
+	t.mu.RUnlock()
+	if ok {
+		return l, nil
+	}
+
+	if !validTopic.MatchString(name) {
+		return nil, api.ErrInvalidTopic{Topic: name}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	//	someone may have created it while we waited for the lock
+	if l, ok := t.logs[name]; ok {
+		return l, nil
+	}
+	e, err := t.journal.begin(opCreateTopic, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.apply(e); err != nil {
+		return nil, err
+	}
+	l, err = NewLog(path.Join(t.Dir, name), t.topicConfig(name))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.journal.commit(e); err != nil {
+		l.Close()
+		return nil, err
+	}
+	t.observe(name, l)
+	t.logs[name] = l
+	l.Config.Logger.Info("topic created")
+	_ = t.Publish(Event{Type: EventTopicCreated, Topic: name})
+	return l, nil
+}
+
+//	Delete removes a topic and all of its data
+func (t *Topics) Delete(name string) error {
+	if name == EventsTopic {
+		return api.ErrInvalidTopic{Topic: name}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.logs[name]
+	if !ok {
+		return api.ErrTopicNotFound{Topic: name}
+	}
+	if err := l.Close(); err != nil {
+		return err
+	}
+	delete(t.logs, name)
+	e, err := t.journal.begin(opDeleteTopic, name)
+	if err != nil {
+		return err
+	}
+	if err := t.apply(e); err != nil {
+		return err
+	}
+	if err := t.journal.commit(e); err != nil {
+		return err
+	}
+	l.Config.Logger.Info("topic deleted")
+	_ = t.Publish(Event{Type: EventTopicDeleted, Topic: name})
+	return nil
+}
+
+//	topicConfig is the config name's log is opened with: the topics' own,
+//		logging with the topic's name attached and compacting with its own
+//		key extractor if it has one
+func (t *Topics) topicConfig(name string) Config {
+	c := t.Config
+	if c.Logger != nil {
+		c.Logger = c.Logger.With("topic", name)
+	}
+	if key, ok := c.Compaction.TopicKeys[name]; ok {
+		c.Compaction.Key = key
+	}
+	return c
+}
+
+//	apply carries out the filesystem side of a journaled operation. Both
+//		operations are safe to repeat, which is what recovery relies on
+func (t *Topics) apply(e journalEntry) error {
+	dir := path.Join(t.Dir, e.Topic)
+	switch e.Op {
+	case opCreateTopic:
+		return os.MkdirAll(dir, 0755)
+	case opDeleteTopic:
+		return os.RemoveAll(dir)
+	}
+	return nil
+}
+
+//	existing returns the log for name without creating it
+func (t *Topics) existing(name string) (*Log, error) {
+	if name == "" {
+		name = DefaultTopic
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	l, ok := t.logs[name]
+	if !ok {
+		return nil, api.ErrTopicNotFound{Topic: name}
+	}
+	return l, nil
+}
+
+//	Names returns every topic, sorted
+func (t *Topics) Names() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	names := make([]string, 0, len(t.logs))
+	for name := range t.logs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//	Integrity returns each topic's startup integrity report
+func (t *Topics) Integrity() map[string]IntegrityReport {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	reports := make(map[string]IntegrityReport, len(t.logs))
+	for name, l := range t.logs {
+		reports[name] = l.Integrity()
+	}
+	return reports
+}
+
+func (t *Topics) Append(topic string, record *api.Record) (uint64, error) {
+	if topic == EventsTopic {
+		return 0, api.ErrInvalidTopic{Topic: topic}
+	}
+	l, err := t.Topic(topic)
+	if err != nil {
+		return 0, err
+	}
+	return l.Append(record)
+}
+
+func (t *Topics) AppendBatch(topic string, records []*api.Record) ([]uint64, error) {
+	if topic == EventsTopic {
+		return nil, api.ErrInvalidTopic{Topic: topic}
+	}
+	l, err := t.Topic(topic)
+	if err != nil {
+		return nil, err
+	}
+	return l.AppendBatch(records)
+}
+
+func (t *Topics) Read(topic string, offset uint64) (*api.Record, error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return nil, err
+	}
+	return l.Read(offset)
+}
+
+//	Offsets returns the bounds of an existing topic
+func (t *Topics) Offsets(topic string) (lowest, highest uint64, err error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lowest, err = l.LowestOffset(); err != nil {
+		return 0, 0, err
+	}
+	if highest, err = l.HighestOffset(); err != nil {
+		return 0, 0, err
+	}
+	return lowest, highest, nil
+}
+
+//	NextOffset returns the offset an existing topic's next record will get
+func (t *Topics) NextOffset(topic string) (uint64, error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return 0, err
+	}
+	return l.NextOffset()
+}
+
+//	OffsetForTime returns the offset of topic's first record at or after
+//		timestamp; see Log.OffsetForTime
+func (t *Topics) OffsetForTime(topic string, timestamp int64) (uint64, error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return 0, err
+	}
+	return l.OffsetForTime(timestamp)
+}
+
+//	Segments describes an existing topic's segments, oldest first
+func (t *Topics) Segments(topic string) ([]SegmentInfo, error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return nil, err
+	}
+	return l.Segments(), nil
+}
+
+//	Truncate removes an existing topic's oldest segments up to lowest (see
+//		Log.Truncate) and returns its lowest offset afterwards
+func (t *Topics) Truncate(topic string, lowest uint64) (uint64, error) {
+	l, err := t.existing(topic)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.Truncate(lowest); err != nil {
+		return 0, err
+	}
+	return l.LowestOffset()
+}
+
+//	Size returns the bytes every topic's log holds
+func (t *Topics) Size() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var size uint64
+	for _, l := range t.logs {
+		size += l.Size()
+	}
+	return size
+}
+
+func (t *Topics) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range t.logs {
+		if err := l.Close(); err != nil {
+			return err
+		}
+	}
+	return t.journal.Close()
+}
+
+func (t *Topics) Remove() error {
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(t.Dir)
+}
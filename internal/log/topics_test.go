@@ -0,0 +1,125 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "topics-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+
+	append := &api.Record{Value: []byte("hello world")}
+	off, err := topics.Append("a", append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	off, err = topics.Append("a", append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	off, err = topics.Append("", append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	_, err = topics.Read("b", 0)
+	require.Equal(t, api.ErrTopicNotFound{Topic: "b"}, err)
+	_, err = topics.Append(".trash", append)
+	require.Equal(t, api.ErrInvalidTopic{Topic: ".trash"}, err)
+
+	require.Equal(t, []string{"a", DefaultTopic}, topics.Names())
+	require.NoError(t, topics.Close())
+
+	topics, err = NewTopics(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", DefaultTopic}, topics.Names())
+	read, err := topics.Read("a", 1)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+	require.NoError(t, topics.Remove())
+}
+
+func TestTopicsJournal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "topics-journal-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topics, err := NewTopics(dir, Config{})
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b"} {
+		_, err = topics.Append(name, &api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, topics.Delete("a"))
+	require.Equal(t, api.ErrTopicNotFound{Topic: "a"}, topics.Delete("a"))
+	require.Equal(t, []string{"b"}, topics.Names())
+	require.NoError(t, topics.Close())
+
+	// crash after a delete of b was journaled but before it was carried out
+	j, pending, err := openJournal(dir)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	_, err = j.begin(opDeleteTopic, "b")
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	topics, err = NewTopics(dir, Config{})
+	require.NoError(t, err)
+	require.Empty(t, topics.Names())
+	_, err = os.Stat(path.Join(dir, "b"))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, topics.Close())
+}
+
+func TestTopicsEvents(t *testing.T) {
+	dir, err := os.MkdirTemp("", "topics-events-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Events.Enabled = true
+	topics, err := NewTopics(dir, c)
+	require.NoError(t, err)
+	defer topics.Close()
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err = topics.Append("a", record)
+		require.NoError(t, err)
+	}
+	l, err := topics.Topic("a")
+	require.NoError(t, err)
+	require.NoError(t, l.Truncate(1))
+	require.NoError(t, topics.Delete("a"))
+
+	_, err = topics.Append(EventsTopic, record)
+	require.Equal(t, api.ErrInvalidTopic{Topic: EventsTopic}, err)
+	require.Equal(t, api.ErrInvalidTopic{Topic: EventsTopic}, topics.Delete(EventsTopic))
+
+	var events []Event
+	for off := uint64(0); ; off++ {
+		record, err := topics.Read(EventsTopic, off)
+		if err != nil {
+			break
+		}
+		var e Event
+		require.NoError(t, json.Unmarshal(record.Value, &e))
+		events = append(events, e)
+	}
+	require.Equal(t, []Event{
+		{Type: EventTopicCreated, Topic: "a"},
+		{Type: EventSegmentSealed, Topic: "a", BaseOffset: 0, NextOffset: 2},
+		{Type: EventSegmentRemoved, Topic: "a", BaseOffset: 0, NextOffset: 2},
+		{Type: EventTopicDeleted, Topic: "a"},
+	}, events)
+}
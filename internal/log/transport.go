@@ -0,0 +1,148 @@
+package log
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//	RaftRPCCompressed starts a raft connection whose traffic is compressed
+//		with zstd (inside TLS, if there is any). Every node accepts both
+//		kinds, so compression can be turned on one node at a time
+const RaftRPCCompressed = 2
+
+//	a connection's compression window; raft's messages are small apart from
+//		snapshots, and each connection in the pool holds its own
+const compressedWindow = 1 << 20
+
+//	compressedConn compresses what's written to a connection and
+//		decompresses what's read from it. Each Write is flushed, so messages
+//		aren't held back waiting for more
+type compressedConn struct {
+	net.Conn
+	w *zstd.Encoder
+	//	with a concurrency of 1 the decoder doesn't read ahead or run
+	//		goroutines of its own, so it needn't be closed
+	r *zstd.Decoder
+}
+
+func newCompressedConn(conn net.Conn) (*compressedConn, error) {
+	w, err := zstd.NewWriter(
+		conn,
+		zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(compressedWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zstd.NewReader(
+		conn,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxWindow(compressedWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedConn{Conn: conn, w: w, r: r}, nil
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+//	Close closes the connection before the encoder, which would otherwise
+//		block writing the end of the frame to a peer that isn't reading. The
+//		other side doesn't need it
+func (c *compressedConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.w.Close()
+	return err
+}
+
+//	throttle is a token bucket of bytes, shared by every connection to a
+//		peer. It holds a second's worth at most; a write bigger than what's
+//		there goes into debt, and the next one waits it off
+type throttle struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newThrottle(bytesPerSecond uint64) *throttle {
+	return &throttle{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+//	wait blocks until n bytes may be sent
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	t.tokens = min(t.rate, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	t.tokens -= float64(n)
+	var d time.Duration
+	if t.tokens < 0 {
+		d = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.mu.Unlock()
+	time.Sleep(d)
+}
+
+//	throttledConn holds the writes to a connection to its peer's rate. They
+//		go out in chunks so one big write (a snapshot, say) doesn't burst
+type throttledConn struct {
+	net.Conn
+	throttle *throttle
+}
+
+const throttleChunk = 32 * 1024
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		c.throttle.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+//	peerThrottle returns the throttle for addr, made the first time it's
+//		dialed, or nil if there's no limit
+func (s *StreamLayer) peerThrottle(addr string) *throttle {
+	if s.peerBytesPerSecond == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.throttles == nil {
+		s.throttles = make(map[string]*throttle)
+	}
+	t, ok := s.throttles[addr]
+	if !ok {
+		t = newThrottle(s.peerBytesPerSecond)
+		s.throttles[addr] = t
+	}
+	return t
+}
@@ -0,0 +1,77 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	b, err := ln.Accept()
+	require.NoError(t, err)
+	client, err := newCompressedConn(a)
+	require.NoError(t, err)
+	server, err := newCompressedConn(b)
+	require.NoError(t, err)
+	defer client.Close()
+	defer server.Close()
+
+	// each write is readable as soon as it's made, in both directions
+	for _, msg := range [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte("append entries "), 1000),
+		[]byte("world"),
+	} {
+		// the write is waited on before the next one, since a conn's
+		// encoder isn't safe for writes at once
+		written := make(chan error, 1)
+		go func() {
+			_, err := client.Write(msg)
+			written <- err
+		}()
+		got := make([]byte, len(msg))
+		_, err := io.ReadFull(server, got)
+		require.NoError(t, err)
+		require.Equal(t, msg, got)
+		require.NoError(t, <-written)
+
+		go func() {
+			_, err := server.Write(msg)
+			written <- err
+		}()
+		_, err = io.ReadFull(client, got)
+		require.NoError(t, err)
+		require.Equal(t, msg, got)
+		require.NoError(t, <-written)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(100 * 1024)
+	// a second's worth goes out straight away, the next half second's waits
+	start := time.Now()
+	th.wait(100 * 1024)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	th.wait(50 * 1024)
+	require.GreaterOrEqual(t, time.Since(start), 450*time.Millisecond)
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	conn := &throttledConn{Conn: a, throttle: newThrottle(1 << 20)}
+	go func() {
+		_, _ = io.Copy(io.Discard, b)
+	}()
+	n, err := conn.Write(make([]byte, 3*throttleChunk+1))
+	require.NoError(t, err)
+	require.Equal(t, 3*throttleChunk+1, n)
+}
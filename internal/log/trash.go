@@ -0,0 +1,99 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//	segments removed by truncation are parked here for Config.Trash.GracePeriod
+//		before being deleted for good. Moving the files back into the log
+//		directory (without the time prefix) while the log is closed restores them
+const trashDir = ".trash"
+
+//	Move closes the segment and moves its files into dir, prefixed with the
+//		time they were moved so they can be purged later. A double-write
+//		scratch file is only any use in place, so it's deleted instead
+func (s *segment) Move(dir string) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	for _, name := range []string{s.index.Name(), s.store.Name(), s.timeIndex.Name()} {
+		dst := path.Join(dir, fmt.Sprintf("%d-%s", now, filepath.Base(name)))
+		if err := os.Rename(name, dst); err != nil {
+			return err
+		}
+	}
+	return s.store.removeScratch()
+}
+
+//	purgeTrash deletes trashed files older than grace
+func purgeTrash(dir string, grace time.Duration) error {
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-grace).UnixNano()
+	for _, file := range files {
+		stamp, _, ok := strings.Cut(file.Name(), "-")
+		if !ok {
+			continue
+		}
+		trashed, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil || trashed > cutoff {
+			continue
+		}
+		if err := os.Remove(path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	emptyTrash purges expired trash every grace period until the log is closed
+func (l *Log) emptyTrash(grace time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(grace)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := purgeTrash(path.Join(l.Dir, trashDir), grace); err != nil {
+				l.Config.Logger.Error("emptying trash failed", "err", err)
+			}
+		}
+	}
+}
+
+//	removeSegment deletes a segment, going through the trash when a grace
+//		period is configured
+func (l *Log) removeSegment(s *segment) error {
+	info := s.info()
+	var err error
+	if l.Config.Trash.GracePeriod > 0 {
+		err = s.Move(path.Join(l.Dir, trashDir))
+	} else {
+		err = s.Remove()
+	}
+	if err != nil {
+		return err
+	}
+	for _, o := range l.observers {
+		if r, ok := o.(SegmentRemovedObserver); ok {
+			r.SegmentRemoved(info)
+		}
+	}
+	return nil
+}
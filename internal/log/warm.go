@@ -0,0 +1,63 @@
+package log
+
+import (
+	"io"
+)
+
+//	warm pre-touches the newest n segments so the first requests after a
+//		restart don't stall on page faults. It runs in the background and
+//		gives up as soon as the log is closed
+func (l *Log) warm(n int, done chan struct{}) {
+	//	the newest segments are the likeliest to be read, so start with them.
+	//		Only the list is taken under the lock; the reading is done without
+	//		it so nothing waits on the disk. A segment that a truncate or a
+	//		compaction closes in the meantime just fails to read
+	l.mu.RLock()
+	var segments []warmer
+	for i := len(l.segments) - 1; i >= 0 && len(segments) < n; i-- {
+		segments = append(segments, l.segments[i].warmer())
+	}
+	l.mu.RUnlock()
+	for _, w := range segments {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		_ = w.warm()
+	}
+}
+
+//	warmer is what warming a segment reads: its index and store files, up to
+//		how much of each was in use when it was taken
+type warmer struct {
+	index, store         io.ReaderAt
+	indexSize, storeSize uint64
+}
+
+//	warmer takes what warming s reads. The caller must hold the log's lock
+func (s *segment) warmer() warmer {
+	return warmer{
+		index:     s.index.file,
+		indexSize: s.index.size,
+		store:     s.store,
+		storeSize: s.store.size,
+	}
+}
+
+//	warm reads the used part of the index and the store through once, so
+//		both sit in the page cache; the index mmap shares it
+func (w warmer) warm() error {
+	buf := make([]byte, 64*1024)
+	for _, f := range []struct {
+		r    io.ReaderAt
+		size uint64
+	}{{w.index, w.indexSize}, {w.store, w.storeSize}} {
+		for off := int64(0); off < int64(f.size); off += int64(len(buf)) {
+			if _, err := f.r.ReadAt(buf, off); err != nil && err != io.EOF {
+				return err
+			}
+		}
+	}
+	return nil
+}
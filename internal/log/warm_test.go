@@ -0,0 +1,60 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentWarm(t *testing.T) {
+	dir, err := os.MkdirTemp("", "warm-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1 << 20
+	c.Segment.MaxIndexBytes = 1 << 20
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// an empty segment has nothing to fault in
+	require.NoError(t, s.warmer().warm())
+
+	want := &api.Record{Value: make([]byte, 100<<10)}
+	for i := 0; i < 3; i++ {
+		_, err := s.Append(want)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.warmer().warm())
+
+	// warming only reads; the records are as they were
+	for off := uint64(0); off < 3; off++ {
+		got, err := s.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, want.Value, got.Value)
+	}
+}
+
+func TestSegmentWarmClosed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "warm-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	_, err = s.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	// warming reads after the log's lock is let go, by which time a
+	// truncate may have closed the segment; it fails rather than touching
+	// an unmapped index
+	w := s.warmer()
+	require.NoError(t, s.Close())
+	require.Error(t, w.warm())
+}
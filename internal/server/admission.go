@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+//	RPCs are put into classes so a burst of one kind (say, heavy consumes)
+//		can't crowd out another (latency sensitive produces)
+type rpcClass int
+
+const (
+	classProduce rpcClass = iota
+	classConsume
+	classAdmin
+	numClasses
+)
+
+//	AdmissionClass configures one class of RPCs
+type AdmissionClass struct {
+	//	share of freed slots the class gets while several classes are
+	//		waiting; defaults to 1
+	Weight int
+	//	most RPCs of the class served at once; 0 means only the overall
+	//		limit applies
+	MaxConcurrent int
+}
+
+func classify(fullMethod string) rpcClass {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	switch {
+	case strings.HasPrefix(method, "Produce"):
+		return classProduce
+	case strings.HasPrefix(method, "Consume"):
+		return classConsume
+	default:
+		return classAdmin
+	}
+}
+
+type classQueue struct {
+	weight   int
+	limit    int
+	inflight int
+	waiting  []chan struct{}
+	//	running credit for smooth weighted round robin
+	current int
+}
+
+//	admission limits how many unary RPCs run at once. When a slot frees up
+//		and more than one class is waiting, classes take turns in proportion
+//		to their weights. Streams aren't admitted here: a tailing
+//		ConsumeStream would hold its slot forever
+type admission struct {
+	mu       sync.Mutex
+	limit    int
+	inflight int
+	classes  [numClasses]*classQueue
+}
+
+func newAdmission(limit int, classes [numClasses]AdmissionClass) *admission {
+	a := &admission{limit: limit}
+	for i, c := range classes {
+		weight := c.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		a.classes[i] = &classQueue{weight: weight, limit: c.MaxConcurrent}
+	}
+	return a
+}
+
+//	acquire waits for a slot for an RPC of class c
+func (a *admission) acquire(ctx context.Context, c rpcClass) error {
+	ready := a.enqueue(c)
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		q := a.classes[c]
+		for i, w := range q.waiting {
+			if w == ready {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		//	the slot was granted while we were giving up; hand it on
+		a.releaseLocked(c)
+		return ctx.Err()
+	}
+}
+
+//	enqueue returns a channel that's closed once the RPC has a slot
+func (a *admission) enqueue(c rpcClass) chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ready := make(chan struct{})
+	q := a.classes[c]
+	q.waiting = append(q.waiting, ready)
+	a.dispatch()
+	return ready
+}
+
+func (a *admission) release(c rpcClass) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.releaseLocked(c)
+}
+
+func (a *admission) releaseLocked(c rpcClass) {
+	a.inflight--
+	a.classes[c].inflight--
+	a.dispatch()
+}
+
+//	dispatch hands free slots to waiting RPCs using smooth weighted round
+//		robin between the classes that can take one
+func (a *admission) dispatch() {
+	for a.inflight < a.limit {
+		var next *classQueue
+		total := 0
+		for _, q := range a.classes {
+			if len(q.waiting) == 0 || (q.limit > 0 && q.inflight >= q.limit) {
+				continue
+			}
+			q.current += q.weight
+			total += q.weight
+			if next == nil || q.current > next.current {
+				next = q
+			}
+		}
+		if next == nil {
+			return
+		}
+		next.current -= total
+		ready := next.waiting[0]
+		next.waiting = next.waiting[1:]
+		next.inflight++
+		a.inflight++
+		close(ready)
+	}
+}
+
+func (a *admission) unaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	c := classify(info.FullMethod)
+	if err := a.acquire(ctx, c); err != nil {
+		return nil, err
+	}
+	defer a.release(c)
+	return handler(ctx, req)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	require.Equal(t, classProduce, classify("/log.v1.Log/ProduceBatch"))
+	require.Equal(t, classConsume, classify("/log.v1.Log/Consume"))
+	require.Equal(t, classAdmin, classify("/log.v1.Log/GetOffsets"))
+}
+
+func TestAdmissionWeights(t *testing.T) {
+	a := newAdmission(1, [numClasses]AdmissionClass{
+		classProduce: {Weight: 3},
+		classConsume: {Weight: 1},
+	})
+	require.NoError(t, a.acquire(context.Background(), classProduce))
+
+	type waiter struct {
+		class rpcClass
+		ready chan struct{}
+	}
+	var waiters []waiter
+	for i := 0; i < 4; i++ {
+		waiters = append(waiters, waiter{classConsume, a.enqueue(classConsume)})
+	}
+	for i := 0; i < 4; i++ {
+		waiters = append(waiters, waiter{classProduce, a.enqueue(classProduce)})
+	}
+
+	// release the running RPC over and over, noting who got the slot each time
+	held := classProduce
+	var order []rpcClass
+	for len(order) < len(waiters) {
+		a.release(held)
+		for i, w := range waiters {
+			select {
+			case <-w.ready:
+				order = append(order, w.class)
+				held = w.class
+				waiters[i].ready = nil
+			default:
+			}
+		}
+	}
+	require.Equal(t, []rpcClass{
+		classProduce, classProduce, classConsume, classProduce,
+		classProduce, classConsume, classConsume, classConsume,
+	}, order)
+}
+
+func TestAdmissionClassLimit(t *testing.T) {
+	a := newAdmission(2, [numClasses]AdmissionClass{
+		classConsume: {MaxConcurrent: 1},
+	})
+	require.NoError(t, a.acquire(context.Background(), classConsume))
+
+	// the second consume has to wait even though there's an overall slot free
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Error(t, a.acquire(ctx, classConsume))
+	require.NoError(t, a.acquire(context.Background(), classProduce))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/NathanClassen/hydralog/internal/auth"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+//	actions checked against the Authorizer
+const (
+	produceAction = auth.ActionProduce
+	consumeAction = auth.ActionConsume
+	adminAction   = auth.ActionAdmin
+)
+
+//	Authorizer decides whether subject, the common name on the client's
+//		certificate, may carry out action on topic. It returns the error to
+//		give the client when it may not
+type Authorizer interface {
+	Authorize(subject, topic, action string) error
+}
+
+//	PrincipalMapper names the principal a verified client certificate acts
+//		as, which is the subject the Authorizer is asked about
+type PrincipalMapper interface {
+	Principal(cert *x509.Certificate) string
+}
+
+type subjectContextKey struct{}
+
+//	authenticator puts the subject of each RPC in its context
+type authenticator struct {
+	principals PrincipalMapper
+}
+
+//	authenticate stores the principal of the verified client certificate in
+//		the context: its common name, unless there's a PrincipalMapper.
+//		Clients without one (plaintext, or TLS without a client certificate)
+//		get an empty subject
+func (a authenticator) authenticate(ctx context.Context) context.Context {
+	var subject string
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			cert := info.State.VerifiedChains[0][0]
+			if a.principals != nil {
+				subject = a.principals.Principal(cert)
+			} else {
+				subject = cert.Subject.CommonName
+			}
+		}
+	}
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+func subject(ctx context.Context) string {
+	s, _ := ctx.Value(subjectContextKey{}).(string)
+	return s
+}
+
+func (a authenticator) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	return handler(a.authenticate(ctx), req)
+}
+
+func (a authenticator) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	return handler(srv, &authenticatedStream{ss, a.authenticate(ss.Context())})
+}
+
+//	authenticatedStream hands the handler a context that carries the subject
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+//	authorize checks the caller may carry out action on topic; everything is
+//		allowed when there's no Authorizer
+func (s *grpcServer) authorize(ctx context.Context, topic, action string) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	if topic == "" {
+		topic = log.DefaultTopic
+	}
+	return s.Authorizer.Authorize(subject(ctx), topic, action)
+}
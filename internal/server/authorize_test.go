@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	"github.com/NathanClassen/hydralog/internal/auth"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestAuthenticate(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject: pkix.Name{CommonName: "api", OrganizationalUnit: []string{"payments"}},
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{cert}},
+		}},
+	})
+
+	require.Equal(t, "api", subject(authenticator{}.authenticate(ctx)))
+
+	principals, err := auth.ParsePrincipals(strings.NewReader("ou, payments, payments\n"))
+	require.NoError(t, err)
+	a := authenticator{principals: principals}
+	require.Equal(t, "payments", subject(a.authenticate(ctx)))
+
+	// plaintext clients have no subject at all
+	require.Equal(t, "", subject(a.authenticate(context.Background())))
+}
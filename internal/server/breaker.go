@@ -0,0 +1,95 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	breaker watches append latency and fast-fails produces once the disk
+//		has been slow (or failing) for too many appends in a row, instead of
+//		letting requests pile up behind it
+type breaker struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	trips     int
+	cooldown  time.Duration
+
+	//	consecutive slow or failed appends
+	failures int
+	//	when set, produces are rejected until this time
+	openUntil time.Time
+	//	a single trial append is let through after the cooldown
+	probing bool
+}
+
+func newBreaker(threshold time.Duration, trips int, cooldown time.Duration) *breaker {
+	if trips <= 0 {
+		trips = 1
+	}
+	return &breaker{
+		threshold: threshold,
+		trips:     trips,
+		cooldown:  cooldown,
+	}
+}
+
+//	allow reports whether an append may go ahead and, if not, roughly how
+//		long until it's worth trying again
+func (b *breaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true, 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return false, wait
+	}
+	if b.probing {
+		return false, b.cooldown
+	}
+	//	cooldown is over; let one append through to see if the disk recovered
+	b.probing = true
+	return true, 0
+}
+
+//	record feeds the outcome of an append back into the breaker. Appends
+//		that failed for reasons other than the storage are left out, though a
+//		probe that did so lets the next append probe instead
+func (b *breaker) record(took time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && !degraded(err) {
+		b.probing = false
+		return
+	}
+	if err == nil && took < b.threshold {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.probing || b.failures >= b.trips {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.probing = false
+	}
+}
+
+//	degraded reports whether an append's error says something about the
+//		storage behind it. A bad request or a node that isn't the leader
+//		doesn't, and counting those would let one client trip the breaker for
+//		every producer
+func degraded(err error) bool {
+	if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return false
+	}
+	return true
+}
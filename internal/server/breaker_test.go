@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func allowed(b *breaker) bool {
+	ok, _ := b.allow()
+	return ok
+}
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(10*time.Millisecond, 2, 20*time.Millisecond)
+
+	require.True(t, allowed(b))
+	b.record(time.Millisecond, nil)
+	b.record(time.Second, nil)
+	require.True(t, allowed(b))
+	b.record(time.Second, nil)
+	// tripped after two slow appends in a row
+	ok, wait := b.allow()
+	require.False(t, ok)
+	require.True(t, wait > 0)
+
+	time.Sleep(30 * time.Millisecond)
+	// only a single probe goes through after the cooldown
+	require.True(t, allowed(b))
+	require.False(t, allowed(b))
+	b.record(time.Second, nil)
+	require.False(t, allowed(b))
+
+	time.Sleep(30 * time.Millisecond)
+	require.True(t, allowed(b))
+	b.record(time.Millisecond, nil)
+	require.True(t, allowed(b))
+	require.True(t, allowed(b))
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	b := newBreaker(10*time.Millisecond, 2, time.Minute)
+
+	b.record(time.Second, nil)
+	// neither a bad topic nor a follower says anything about the disk, and
+	// they don't reset the slow append before them either
+	for i := 0; i < 5; i++ {
+		b.record(time.Millisecond, api.ErrInvalidTopic{Topic: ".events"})
+		b.record(time.Millisecond, raft.ErrNotLeader)
+		b.record(time.Millisecond, api.ErrNotLeader{})
+	}
+	require.True(t, allowed(b))
+	b.record(time.Millisecond, errors.New("input/output error"))
+	require.False(t, allowed(b))
+}
+
+func TestBreakerProbeClientError(t *testing.T) {
+	b := newBreaker(10*time.Millisecond, 1, 20*time.Millisecond)
+	b.record(time.Second, nil)
+	require.False(t, allowed(b))
+
+	time.Sleep(30 * time.Millisecond)
+	require.True(t, allowed(b))
+	// the probe told us nothing about the disk, so another append probes
+	b.record(time.Millisecond, api.ErrInvalidTopic{Topic: ".events"})
+	require.True(t, allowed(b))
+	b.record(time.Millisecond, nil)
+	require.True(t, allowed(b))
+	require.True(t, allowed(b))
+}
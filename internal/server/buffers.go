@@ -0,0 +1,70 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	buffers accounts for the bytes of records ConsumeStreams hold between
+//		reading them from the log and gRPC taking them to send, per stream
+//		and per connection. A stream sending to a slow consumer holds its
+//		record until flow control lets it go, so a connection with many
+//		streams, or a stream with a huge record, is where that memory adds
+//		up. A limit of 0 is no limit
+type buffers struct {
+	stream uint64
+	conn   uint64
+
+	mu    sync.Mutex
+	conns map[string]uint64
+	//	for the metrics: what's held across every connection now, and how
+	//		many streams have been reset since the last sample
+	held   atomic.Uint64
+	resets atomic.Uint64
+}
+
+func newBuffers(stream, conn uint64) *buffers {
+	return &buffers{
+		stream: stream,
+		conn:   conn,
+		conns:  make(map[string]uint64),
+	}
+}
+
+//	reserve accounts for n more bytes held by a stream on conn that already
+//		holds held, or fails with ResourceExhausted, counting the reset, if
+//		that would take the stream or the connection over its limit
+func (b *buffers) reserve(conn string, held, n uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stream > 0 && held+n > b.stream {
+		b.resets.Add(1)
+		return status.Errorf(
+			codes.ResourceExhausted,
+			"consume stream would buffer %d bytes, over its limit of %d", held+n, b.stream,
+		)
+	}
+	if b.conn > 0 && b.conns[conn]+n > b.conn {
+		b.resets.Add(1)
+		return status.Errorf(
+			codes.ResourceExhausted,
+			"connection's consume streams would buffer %d bytes, over its limit of %d", b.conns[conn]+n, b.conn,
+		)
+	}
+	b.conns[conn] += n
+	b.held.Add(n)
+	return nil
+}
+
+//	release gives back n bytes reserved on conn
+func (b *buffers) release(conn string, n uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conns[conn] -= n; b.conns[conn] == 0 {
+		delete(b.conns, conn)
+	}
+	b.held.Add(^(n - 1))
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBuffers(t *testing.T) {
+	b := newBuffers(100, 150)
+
+	require.NoError(t, b.reserve("a", 0, 80))
+	// a stream can't hold more than its own limit
+	err := b.reserve("a", 80, 30)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// nor can a connection's streams together
+	err = b.reserve("a", 0, 80)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// other connections have their own
+	require.NoError(t, b.reserve("b", 0, 80))
+	require.Equal(t, uint64(160), b.held.Load())
+	require.Equal(t, uint64(2), b.resets.Load())
+
+	b.release("a", 80)
+	require.NoError(t, b.reserve("a", 0, 80))
+	b.release("a", 80)
+	b.release("b", 80)
+	require.Equal(t, uint64(0), b.held.Load())
+	require.Empty(t, b.conns)
+
+	none := newBuffers(0, 0)
+	require.NoError(t, none.reserve("a", 0, 1<<30))
+}
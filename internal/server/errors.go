@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+//	helpers for building errors that carry google.rpc details, so clients can
+//		react to them without matching on message text. If the details can't be
+//		attached the plain status is still returned
+
+//	errBadRequest reports a malformed field in a request
+func errBadRequest(field, description string) error {
+	st := status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("invalid %s: %s", field, description),
+	)
+	d := &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       field,
+			Description: description,
+		}},
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st.Err()
+	}
+	return std.Err()
+}
+
+//	errUnavailable reports a temporary condition along with how long the
+//		client should wait before retrying
+func errUnavailable(msg string, retryAfter time.Duration) error {
+	st := status.New(codes.Unavailable, msg)
+	d := &errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st.Err()
+	}
+	return std.Err()
+}
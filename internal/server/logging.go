@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	rpcLogger logs RPCs that fail
+type rpcLogger struct {
+	logger *slog.Logger
+}
+
+func (l rpcLogger) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+	l.log(ctx, info.FullMethod, start, err)
+	return res, err
+}
+
+func (l rpcLogger) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	l.log(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func (l rpcLogger) log(ctx context.Context, method string, start time.Time, err error) {
+	if err == nil {
+		return
+	}
+	code := status.Code(err)
+	level := slog.LevelError
+	if clientCaused(code) {
+		level = slog.LevelDebug
+	}
+	l.logger.Log(ctx, level, "rpc failed",
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+		"err", err,
+	)
+}
+
+//	clientCaused reports whether code is the client's doing (a bad request,
+//		a missing permission, hanging up) rather than something wrong with
+//		the server worth an operator's attention
+func clientCaused(code codes.Code) bool {
+	switch code {
+	case codes.InvalidArgument, codes.NotFound, codes.OutOfRange,
+		codes.AlreadyExists, codes.FailedPrecondition,
+		codes.PermissionDenied, codes.Unauthenticated, codes.Canceled:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRPCLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := rpcLogger{slog.New(slog.NewTextHandler(&buf, nil))}
+	ctx := context.Background()
+
+	l.log(ctx, "/log.v1.Log/Produce", time.Now(), nil)
+	require.Empty(t, buf.String())
+
+	// the client's mistakes are only worth a debug line
+	l.log(ctx, "/log.v1.Log/Consume", time.Now(), status.Error(codes.OutOfRange, "offset out of range"))
+	require.Empty(t, buf.String())
+
+	l.log(ctx, "/log.v1.Log/Produce", time.Now(), status.Error(codes.Internal, "disk full"))
+	require.Contains(t, buf.String(), "level=ERROR")
+	require.Contains(t, buf.String(), "method=/log.v1.Log/Produce code=Internal")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+)
+
+//	Sizer may also be implemented by the CommitLog to report how many bytes it
+//		takes up on disk
+type Sizer interface {
+	Size() uint64
+}
+
+//	metrics keeps the most recent samples of the server's key metrics in a
+//		ring, so recent trends can be shown without an external metrics
+//		system
+type metrics struct {
+	appended atomic.Uint64
+	consumed atomic.Uint64
+
+	mu      sync.Mutex
+	samples []*api.MetricsSample
+	next    int
+	full    bool
+}
+
+//	how far back the samples go when Metrics.Samples isn't set
+const metricsHistory = 3 * time.Hour
+
+func newMetrics(interval time.Duration, samples int) *metrics {
+	if samples == 0 && interval > 0 {
+		samples = int(metricsHistory / interval)
+	}
+	return &metrics{samples: make([]*api.MetricsSample, max(samples, 1))}
+}
+
+//	sample records a sample every interval until the server drains
+func (s *grpcServer) sample(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.draining:
+			return
+		case now := <-ticker.C:
+			sample := &api.MetricsSample{
+				Timestamp:            now.UnixNano(),
+				AppendRate:           float64(s.metrics.appended.Swap(0)) / interval.Seconds(),
+				ConsumeRate:          float64(s.metrics.consumed.Swap(0)) / interval.Seconds(),
+				ConsumeBufferedBytes: s.buffers.held.Load(),
+				ConsumeStreamResets:  s.buffers.resets.Swap(0),
+			}
+			if sizer, ok := s.CommitLog.(Sizer); ok {
+				sample.DiskBytes = sizer.Size()
+			}
+			s.metrics.add(sample)
+		}
+	}
+}
+
+func (m *metrics) add(sample *api.MetricsSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples[m.next] = sample
+	m.next = (m.next + 1) % len(m.samples)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+//	history returns the samples, oldest first
+func (m *metrics) history() []*api.MetricsSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.full {
+		return append([]*api.MetricsSample(nil), m.samples[:m.next]...)
+	}
+	history := append([]*api.MetricsSample(nil), m.samples[m.next:]...)
+	return append(history, m.samples[:m.next]...)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHistory(t *testing.T) {
+	m := newMetrics(time.Second, 3)
+	timestamps := func() []int64 {
+		var ts []int64
+		for _, s := range m.history() {
+			ts = append(ts, s.Timestamp)
+		}
+		return ts
+	}
+	require.Empty(t, timestamps())
+
+	for i := int64(1); i <= 2; i++ {
+		m.add(&api.MetricsSample{Timestamp: i})
+	}
+	require.Equal(t, []int64{1, 2}, timestamps())
+
+	// once the ring is full the oldest samples are overwritten
+	for i := int64(3); i <= 5; i++ {
+		m.add(&api.MetricsSample{Timestamp: i})
+	}
+	require.Equal(t, []int64{3, 4, 5}, timestamps())
+
+	require.Len(t, newMetrics(10*time.Second, 0).samples, 1080)
+}
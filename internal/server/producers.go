@@ -0,0 +1,110 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
+)
+
+const (
+	//	a producer that hasn't appended for this long is forgotten; one that
+	//		reconnects after that gets its resends appended again
+	producerIdle = 15 * time.Minute
+	//	most producers remembered at once, past which the longest idle one is
+	//		forgotten to make room
+	maxProducers = 100000
+)
+
+//	producers remembers the highest sequence appended by each producer to
+//		each topic, so a producer that reconnects and resends its unacked
+//		window doesn't get records appended twice. A producer should only
+//		have one ProduceStream open per topic at a time. It's only this
+//		server's memory: resends that reach another server, or this one
+//		after a restart, are appended again
+type producers struct {
+	mu   sync.Mutex
+	last map[producerKey]*list.Element
+	//	producers by when they last appended, most recent first
+	lru *list.List
+	now func() time.Time
+}
+
+//	sequences are per topic; the same producer writing to two topics has a
+//		sequence for each
+type producerKey struct {
+	id    string
+	topic string
+}
+
+type producerState struct {
+	key  producerKey
+	res  *api.ProduceResponse
+	seen time.Time
+}
+
+func newProducers() *producers {
+	return &producers{
+		last: make(map[producerKey]*list.Element),
+		lru:  list.New(),
+		now:  time.Now,
+	}
+}
+
+func keyFor(id, topic string) producerKey {
+	if topic == "" {
+		topic = log.DefaultTopic
+	}
+	return producerKey{id: id, topic: topic}
+}
+
+//	appended returns an ack covering sequence if the producer already had it
+//		appended to topic
+func (p *producers) appended(id, topic string, sequence uint64) (*api.ProduceResponse, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.last[keyFor(id, topic)]
+	if !ok {
+		return nil, false
+	}
+	last := e.Value.(*producerState)
+	if sequence > last.res.Sequence {
+		return nil, false
+	}
+	last.seen = p.now()
+	p.lru.MoveToFront(e)
+	return &api.ProduceResponse{Offset: last.res.Offset, Sequence: last.res.Sequence}, true
+}
+
+func (p *producers) record(id, topic string, res *api.ProduceResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	//	the idle producers are all at the back
+	for e := p.lru.Back(); e != nil; e = p.lru.Back() {
+		if now.Sub(e.Value.(*producerState).seen) <= producerIdle {
+			break
+		}
+		p.forget(e)
+	}
+	key := keyFor(id, topic)
+	res = &api.ProduceResponse{Offset: res.Offset, Sequence: res.Sequence}
+	if e, ok := p.last[key]; ok {
+		state := e.Value.(*producerState)
+		state.res, state.seen = res, now
+		p.lru.MoveToFront(e)
+		return
+	}
+	if len(p.last) >= maxProducers {
+		p.forget(p.lru.Back())
+	}
+	p.last[key] = p.lru.PushFront(&producerState{key: key, res: res, seen: now})
+}
+
+//	forget drops a producer. The caller must hold the lock
+func (p *producers) forget(e *list.Element) {
+	p.lru.Remove(e)
+	delete(p.last, e.Value.(*producerState).key)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducers(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := newProducers()
+	p.now = func() time.Time { return now }
+
+	p.record("p1", "orders", &api.ProduceResponse{Offset: 7, Sequence: 3})
+	res, ok := p.appended("p1", "orders", 2)
+	require.True(t, ok)
+	require.Equal(t, uint64(7), res.Offset)
+	require.Equal(t, uint64(3), res.Sequence)
+	_, ok = p.appended("p1", "orders", 4)
+	require.False(t, ok)
+
+	// the same producer's sequences on another topic are its own
+	_, ok = p.appended("p1", "payments", 1)
+	require.False(t, ok)
+	p.record("p1", "", &api.ProduceResponse{Offset: 0, Sequence: 1})
+	_, ok = p.appended("p1", "default", 1)
+	require.True(t, ok)
+
+	// producers that go quiet are forgotten
+	now = now.Add(producerIdle / 2)
+	_, ok = p.appended("p1", "orders", 3)
+	require.True(t, ok)
+	now = now.Add(producerIdle + time.Second)
+	p.record("p2", "orders", &api.ProduceResponse{Offset: 8, Sequence: 1})
+	require.Len(t, p.last, 1)
+	_, ok = p.appended("p1", "orders", 3)
+	require.False(t, ok)
+}
+
+func TestProducersEvictLeastRecent(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := newProducers()
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < maxProducers; i++ {
+		p.record(fmt.Sprintf("p%d", i), "orders", &api.ProduceResponse{Sequence: 1})
+	}
+	// a resend counts as use, so p0 is no longer the least recent
+	_, ok := p.appended("p0", "orders", 1)
+	require.True(t, ok)
+
+	p.record("new", "orders", &api.ProduceResponse{Sequence: 1})
+	require.Len(t, p.last, maxProducers)
+	_, ok = p.appended("p0", "orders", 1)
+	require.True(t, ok)
+	_, ok = p.appended("p1", "orders", 1)
+	require.False(t, ok)
+}
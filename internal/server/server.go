@@ -2,13 +2,81 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"log/slog"
+	"sync"
+	"time"
 
 	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type Config struct {
 	CommitLog CommitLog
+	//	serve over TLS with these settings, usually from
+	//		tlsconfig.SetupTLSConfig. A ClientCAs pool there makes clients
+	//		authenticate with a certificate too. nil serves plaintext
+	TLS *tls.Config
+	//	checks each request's topic against what the client may do; nil
+	//		lets everyone do everything
+	Authorizer Authorizer
+	//	maps client certificates to the subjects the Authorizer sees, e.g.
+	//		an auth.Principals; nil uses the certificate's common name
+	PrincipalMapper PrincipalMapper
+	//	answers GetServers; nil leaves it unimplemented, for a node that
+	//		isn't part of a cluster
+	ServerGetter ServerGetter
+	//	gRPC's receive windows: how many bytes a client may send on a single
+	//		stream and on a whole connection before it has to wait for the
+	//		server to read them. Values below gRPC's 64KiB minimum are
+	//		ignored; 0 keeps the defaults
+	StreamWindowBytes int32
+	ConnWindowBytes   int32
+	//	most bytes of records a single ConsumeStream, and all the
+	//		ConsumeStreams of one connection together, may hold while they
+	//		wait for gRPC to take them. A stream that would go over is reset
+	//		with ResourceExhausted; 0 is no limit
+	StreamBufferBytes uint64
+	ConnBufferBytes   uint64
+	//	most sequenced records ProduceStream acknowledges with one response;
+	//		0 acks whenever the stream has no more requests waiting
+	ProduceAckWindow uint32
+	//	most ConsumeStreams a single topic may have open at once, so a
+	//		runaway fleet of consumers can't pile up tails; 0 is no limit
+	ConsumeStreamsPerTopic int
+	//	admission control for unary RPCs: at most MaxConcurrent run at once,
+	//		with per-class limits and weights deciding who goes next. 0
+	//		disables it
+	Admission struct {
+		MaxConcurrent int
+		Produce       AdmissionClass
+		Consume       AdmissionClass
+		Admin         AdmissionClass
+	}
+	//	trip a circuit breaker on produces once Trips appends in a row took
+	//		longer than LatencyThreshold (or failed); produces are then
+	//		rejected as Unavailable for Cooldown. A zero threshold disables it
+	Breaker struct {
+		LatencyThreshold time.Duration
+		Trips            int
+		Cooldown         time.Duration
+	}
+	//	keep Samples of the append and consume rates and the log's size, one
+	//		every Interval, for GetMetrics. Samples defaults to three hours'
+	//		worth; a zero Interval disables sampling
+	Metrics struct {
+		Interval time.Duration
+		Samples  int
+	}
+	//	where failed RPCs are logged: at debug for errors the client caused,
+	//		at error for the rest. nil doesn't log them
+	Logger *slog.Logger
 }
 
 // a type assertion. We use a blank identifier because we don't actually need a variable here
@@ -17,84 +85,501 @@ type Config struct {
 //	implements the LogServer interface
 var _ api.LogServer = (*grpcServer)(nil)
 
-func NewGRPCServer(config *Config) (*grpc.Server, error) {
-	gsrv := grpc.NewServer()
+func NewGRPCServer(config *Config) (*Server, error) {
+	var opts []grpc.ServerOption
+	if config.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config.TLS)))
+	}
+	if config.StreamWindowBytes > 0 {
+		opts = append(opts, grpc.InitialWindowSize(config.StreamWindowBytes))
+	}
+	if config.ConnWindowBytes > 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(config.ConnWindowBytes))
+	}
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	//	outermost, so it sees every error the other interceptors return
+	if config.Logger != nil {
+		l := rpcLogger{config.Logger}
+		unary = append(unary, l.unary)
+		stream = append(stream, l.stream)
+	}
+	if config.Authorizer != nil {
+		a := authenticator{principals: config.PrincipalMapper}
+		unary = append(unary, a.unary)
+		stream = append(stream, a.stream)
+	}
+	if config.Admission.MaxConcurrent > 0 {
+		a := newAdmission(config.Admission.MaxConcurrent, [numClasses]AdmissionClass{
+			classProduce: config.Admission.Produce,
+			classConsume: config.Admission.Consume,
+			classAdmin:   config.Admission.Admin,
+		})
+		unary = append(unary, a.unaryInterceptor)
+	}
+	if len(unary) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(unary...))
+	}
+	if len(stream) > 0 {
+		opts = append(opts, grpc.ChainStreamInterceptor(stream...))
+	}
+	gsrv := grpc.NewServer(opts...)
 	srv, err := newgrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
 	api.RegisterLogServer(gsrv, srv)
-	return gsrv, nil
+	return &Server{Server: gsrv, srv: srv}, nil
 }
 
 type grpcServer struct {
 	api.UnimplementedLogServer
 	*Config
+	//	closed once Shutdown starts
+	draining  chan struct{}
+	drainOnce sync.Once
+	breaker   *breaker
+	buffers   *buffers
+	producers *producers
+	streams   *streams
+	metrics   *metrics
 }
 
 func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 	srv = &grpcServer{
-		Config: config,
+		Config:    config,
+		producers: newProducers(),
+		streams:   newStreams(config.ConsumeStreamsPerTopic),
+		buffers:   newBuffers(config.StreamBufferBytes, config.ConnBufferBytes),
+		draining:  make(chan struct{}),
+		metrics:   newMetrics(config.Metrics.Interval, config.Metrics.Samples),
+	}
+	if config.Metrics.Interval > 0 {
+		go srv.sample(config.Metrics.Interval)
+	}
+	if config.Breaker.LatencyThreshold > 0 {
+		srv.breaker = newBreaker(
+			config.Breaker.LatencyThreshold,
+			config.Breaker.Trips,
+			config.Breaker.Cooldown,
+		)
 	}
 	return srv, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	offset, err := s.CommitLog.Append(req.Record)
+	if req.Record == nil {
+		return nil, errBadRequest("record", "a record is required")
+	}
+	if err := s.authorize(ctx, req.Topic, produceAction); err != nil {
+		return nil, err
+	}
+	if s.breaker != nil {
+		if ok, wait := s.breaker.allow(); !ok {
+			return nil, errUnavailable("log appends are degraded, retry later", wait)
+		}
+	}
+	start := time.Now()
+	offset, err := s.CommitLog.Append(req.Topic, req.Record)
+	if s.breaker != nil {
+		s.breaker.record(time.Since(start), err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	s.metrics.appended.Add(1)
 	return &api.ProduceResponse{Offset: offset}, nil
 }
 
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	if len(req.Records) == 0 {
+		return nil, errBadRequest("records", "at least one record is required")
+	}
+	for _, record := range req.Records {
+		if record == nil {
+			return nil, errBadRequest("records", "records can't be empty")
+		}
+	}
+	if err := s.authorize(ctx, req.Topic, produceAction); err != nil {
+		return nil, err
+	}
+	if s.breaker != nil {
+		if ok, wait := s.breaker.allow(); !ok {
+			return nil, errUnavailable("log appends are degraded, retry later", wait)
+		}
+	}
+	start := time.Now()
+	offsets, err := s.CommitLog.AppendBatch(req.Topic, req.Records)
+	if s.breaker != nil {
+		s.breaker.record(time.Since(start), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.appended.Add(uint64(len(offsets)))
+	return &api.ProduceBatchResponse{Offsets: offsets}, nil
+}
+
+func (s *grpcServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	lowest, highest, err := s.CommitLog.Offsets(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.CommitLog.NextOffset(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetOffsetsResponse{
+		LowestOffset:  lowest,
+		HighestOffset: highest,
+		NextOffset:    next,
+	}, nil
+}
+
+func (s *grpcServer) GetOffsetForTime(ctx context.Context, req *api.GetOffsetForTimeRequest) (*api.GetOffsetForTimeResponse, error) {
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	offset, err := s.CommitLog.OffsetForTime(req.Topic, req.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetOffsetForTimeResponse{Offset: offset}, nil
+}
+
+//	GetConsumeStreams lists the ConsumeStreams open on this server for a
+//		topic; other servers in the cluster have their own
+func (s *grpcServer) GetConsumeStreams(ctx context.Context, req *api.GetConsumeStreamsRequest) (*api.GetConsumeStreamsResponse, error) {
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	return &api.GetConsumeStreamsResponse{Streams: s.streams.list(req.Topic)}, nil
+}
+
+//	CloneTopic forks a topic into a new one, for trying consumers out on a
+//		copy of real data. It needs consume on the topic and produce on the
+//		clone
+func (s *grpcServer) CloneTopic(ctx context.Context, req *api.CloneTopicRequest) (*api.CloneTopicResponse, error) {
+	cloner, ok := s.CommitLog.(Cloner)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "this log can't clone topics")
+	}
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, req.Clone, produceAction); err != nil {
+		return nil, err
+	}
+	highest, err := cloner.CloneTopic(req.Topic, req.Clone)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CloneTopicResponse{Highest: highest}, nil
+}
+
+//	GetSegments lists a topic's segments as this server has them
+func (s *grpcServer) GetSegments(ctx context.Context, req *api.GetSegmentsRequest) (*api.GetSegmentsResponse, error) {
+	lister, ok := s.CommitLog.(SegmentLister)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "this log can't list segments")
+	}
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	infos, err := lister.Segments(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	res := &api.GetSegmentsResponse{}
+	for _, info := range infos {
+		res.Segments = append(res.Segments, &api.SegmentInfo{
+			BaseOffset: info.BaseOffset,
+			NextOffset: info.NextOffset,
+			StoreBytes: info.StoreBytes,
+			IndexBytes: info.IndexBytes,
+		})
+	}
+	return res, nil
+}
+
+//	TruncateTopic drops a topic's oldest segments. Removing data takes admin
+//		on the topic
+func (s *grpcServer) TruncateTopic(ctx context.Context, req *api.TruncateTopicRequest) (*api.TruncateTopicResponse, error) {
+	truncater, ok := s.CommitLog.(Truncater)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "this log can't be truncated")
+	}
+	if err := s.authorize(ctx, req.Topic, adminAction); err != nil {
+		return nil, err
+	}
+	lowest, err := truncater.Truncate(req.Topic, req.Lowest)
+	if err != nil {
+		return nil, err
+	}
+	return &api.TruncateTopicResponse{Lowest: lowest}, nil
+}
+
+func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+	if s.ServerGetter == nil {
+		return nil, status.Error(codes.Unimplemented, "this server isn't part of a cluster")
+	}
+	servers, err := s.ServerGetter.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetServersResponse{Servers: servers}, nil
+}
+
+func (s *grpcServer) GetMetrics(ctx context.Context, req *api.GetMetricsRequest) (*api.GetMetricsResponse, error) {
+	if s.Config.Metrics.Interval == 0 {
+		return nil, status.Error(codes.Unimplemented, "metrics aren't being sampled")
+	}
+	return &api.GetMetricsResponse{Samples: s.metrics.history()}, nil
+}
+
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	record, err := s.CommitLog.Read(req.Offset)
+	if err := s.authorize(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+	if req.Linearizable {
+		if err := s.verifyRead(); err != nil {
+			return nil, err
+		}
+	}
+	record, err := s.CommitLog.Read(req.Topic, req.Offset)
 	if err != nil {
 		return nil, err
 	}
+	s.metrics.consumed.Add(1)
 	return &api.ConsumeResponse{Record: record}, nil
 }
 
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
+	//	requests are received on their own goroutine so the loop below can
+	//		tell whether more are already waiting before it sends an ack
+	reqs := make(chan *api.ProduceRequest)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	//	the latest sequenced record that hasn't been acknowledged yet, and how
+	//		many records that ack will cover
+	var pending *api.ProduceResponse
+	var unacked uint32
+	flush := func() error {
+		if pending == nil {
+			return nil
+		}
+		res := pending
+		pending, unacked = nil, 0
+		return stream.Send(res)
+	}
+
 	for {
-		req, err := stream.Recv()
-		if err != nil {
-			return err
+		var req *api.ProduceRequest
+		select {
+		case req = <-reqs:
+		default:
+			//	nothing else is waiting, so don't hold the ack back any longer
+			if err := flush(); err != nil {
+				return err
+			}
+			select {
+			case req = <-reqs:
+			case err := <-errc:
+				return err
+			case <-s.draining:
+				return errShuttingDown
+			}
+		}
+
+		//	a resend after a reconnect; the ack is cumulative so re-acking
+		//		the highest appended sequence covers it. The ack gives away
+		//		an offset in the topic, so it's only for those who may
+		//		produce to it
+		if req.ProducerId != "" && req.Sequence != 0 {
+			if err := s.authorize(stream.Context(), req.Topic, produceAction); err != nil {
+				return err
+			}
+			if res, ok := s.producers.appended(req.ProducerId, req.Topic, req.Sequence); ok {
+				pending = res
+				unacked++
+				continue
+			}
 		}
+
 		res, err := s.Produce(stream.Context(), req)
 		if err != nil {
 			return err
 		}
-		if err = stream.Send(res); err != nil {
-			return err
+		//	records without a sequence are acked one by one
+		if req.Sequence == 0 {
+			if err = flush(); err != nil {
+				return err
+			}
+			if err = stream.Send(res); err != nil {
+				return err
+			}
+			continue
+		}
+		res.Sequence = req.Sequence
+		if req.ProducerId != "" {
+			s.producers.record(req.ProducerId, req.Topic, res)
+		}
+		pending = res
+		unacked++
+		if s.ProduceAckWindow > 0 && unacked >= s.ProduceAckWindow {
+			if err = flush(); err != nil {
+				return err
+			}
 		}
 	}
 }
 
+//	how long a ConsumeStream that's caught up waits before looking for new
+//		records, doubling from the min to the max while none turn up
+const (
+	minConsumePoll = time.Millisecond
+	maxConsumePoll = 50 * time.Millisecond
+)
+
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	if req.UntilOffset != nil && *req.UntilOffset < req.Offset {
+		return errBadRequest("until_offset", "must not be lower than offset")
+	}
+	//	one check covers the whole stream; the Consumes below needn't repeat it
+	if req.Linearizable {
+		if err := s.verifyRead(); err != nil {
+			return err
+		}
+		req.Linearizable = false
+	}
+	var addr string
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+	cs, err := s.streams.add(req.Topic, subject(stream.Context()), addr, req.Offset)
+	if err != nil {
+		return err
+	}
+	defer s.streams.remove(cs)
+	var wait time.Duration
 	for {
 		select {
 		case <-stream.Context().Done():
 			return nil
+		case <-s.draining:
+			return errShuttingDown
 		default:
+			//	bounded replays stop right at the boundary
+			if req.UntilOffset != nil && req.Offset > *req.UntilOffset {
+				return nil
+			}
 			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
+			switch err := err.(type) {
 			case nil:
+				wait = 0
 			case api.ErrOffsetOutOfRange:
+				//	what's below the log is gone for good; past its end the
+				//		record just hasn't been written yet, so poll for it
+				if err.Offset < err.Lowest {
+					return err
+				}
+				wait = min(max(2*wait, minConsumePoll), maxConsumePoll)
+				select {
+				case <-stream.Context().Done():
+					return nil
+				case <-s.draining:
+					return errShuttingDown
+				case <-time.After(wait):
+				}
 				continue
 			default:
 				return err
 			}
 
-			if err = stream.Send(res); err != nil {
+			//	the log skips over offsets compaction removed, so the record
+			//		may be further on than asked for
+			if req.UntilOffset != nil && res.Record.Offset > *req.UntilOffset {
+				return nil
+			}
+			n := uint64(proto.Size(res))
+			if err = s.buffers.reserve(addr, 0, n); err != nil {
+				return err
+			}
+			cs.buffered.Store(n)
+			err = stream.Send(res)
+			cs.buffered.Store(0)
+			s.buffers.release(addr, n)
+			if err != nil {
 				return err
 			}
-			req.Offset++
+			req.Offset = res.Record.Offset + 1
+			cs.offset.Store(req.Offset)
 		}
 	}
 }
 
+//	CommitLog is a set of logs keyed by topic; an empty topic is the default
 type CommitLog interface {
-	Append(*api.Record) (uint64, error)
-	Read(uint64) (*api.Record, error)
+	Append(topic string, record *api.Record) (uint64, error)
+	AppendBatch(topic string, records []*api.Record) ([]uint64, error)
+	Read(topic string, offset uint64) (*api.Record, error)
+	Offsets(topic string) (lowest, highest uint64, err error)
+	NextOffset(topic string) (uint64, error)
+	OffsetForTime(topic string, timestamp int64) (uint64, error)
+}
+
+//	Linearizer is implemented by commit logs whose reads can be stale, like
+//		a replica that's behind its leader. VerifyRead returns once reads
+//		will see every write acknowledged before it was called
+type Linearizer interface {
+	VerifyRead() error
+}
+
+//	verifyRead is what a linearizable read waits on. A log without a
+//		Linearizer has nothing to lag behind, so its reads already are
+func (s *grpcServer) verifyRead() error {
+	if l, ok := s.CommitLog.(Linearizer); ok {
+		return l.VerifyRead()
+	}
+	return nil
+}
+
+//	Cloner is implemented by commit logs that can fork a topic for
+//		CloneTopic
+type Cloner interface {
+	CloneTopic(topic, clone string) (uint64, error)
+}
+
+//	SegmentLister is implemented by commit logs that can describe a topic's
+//		segments for GetSegments
+type SegmentLister interface {
+	Segments(topic string) ([]log.SegmentInfo, error)
+}
+
+//	Truncater is implemented by commit logs that can drop a topic's oldest
+//		segments for TruncateTopic
+type Truncater interface {
+	Truncate(topic string, lowest uint64) (uint64, error)
+}
+
+//	ServerGetter lists the nodes of the cluster this server belongs to
+type ServerGetter interface {
+	GetServers() ([]*api.Server, error)
 }
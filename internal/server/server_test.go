@@ -2,15 +2,25 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/auth"
 	"github.com/NathanClassen/hydralog/internal/log"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func TestServer(t *testing.T) {
@@ -22,6 +32,17 @@ func TestServer(t *testing.T) {
 		"produce/consume a message to/from the log succeeds":testProduceConsume,
 		"produce/consume stream succeeds": testProduceConsumeStream,
 		"consume past log boundary fails": testConsumePastBoundary,
+		"produce stream acks sequences cumulatively": testProduceStreamCumulativeAck,
+		"bounded consume stream ends at until offset": testConsumeStreamUntil,
+		"produce without a record is a bad request":  testProduceBadRequest,
+		"resent records are not appended twice":      testProduceStreamResend,
+		"records are routed by topic":                testTopics,
+		"produce a batch of records":                 testProduceBatch,
+		"get offsets reports the log's bounds":       testGetOffsets,
+		"get servers needs a cluster":                testGetServersUnimplemented,
+		"get offset for time":                        testGetOffsetForTime,
+		"clone a topic":                              testCloneTopic,
+		"list segments and truncate":                 testSegmentsTruncate,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			client, config, teardown := setupTest(t, nil)
@@ -31,6 +52,58 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestServerWindowLimits(t *testing.T) {
+	client, config, teardown := setupTest(t, func(c *Config) {
+		c.StreamWindowBytes = 64 * 1024
+		c.ConnWindowBytes = 128 * 1024
+	})
+	defer teardown()
+	testProduceConsumeStream(t, client, config)
+
+	// a request bigger than both windows still gets through, a window at a
+	// time
+	value := make([]byte, 512*1024)
+	res, err := client.Produce(context.Background(), &api.ProduceRequest{
+		Record: &api.Record{Value: value},
+	})
+	require.NoError(t, err)
+	got, err := client.Consume(context.Background(), &api.ConsumeRequest{Offset: res.Offset})
+	require.NoError(t, err)
+	require.Equal(t, value, got.Record.Value)
+}
+
+func TestServerBufferLimits(t *testing.T) {
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.StreamBufferBytes = 1024
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	for _, size := range []int{100, 2048} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: make([]byte, size)},
+		})
+		require.NoError(t, err)
+	}
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Record.Offset)
+	// the second record is more than the stream may hold
+	_, err = stream.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServerAdmission(t *testing.T) {
+	client, config, teardown := setupTest(t, func(c *Config) {
+		c.Admission.MaxConcurrent = 1
+		c.Admission.Produce.Weight = 2
+	})
+	defer teardown()
+	testProduceConsume(t, client, config)
+}
+
 func setupTest(t *testing.T, fn func(*Config)) (
 	client api.LogClient,
 	cfg *Config,
@@ -47,7 +120,7 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	dir, err := os.MkdirTemp("", "server-test")
 	require.NoError(t, err)
 
-	clog, err := log.NewLog(dir, log.Config{})
+	clog, err := log.NewTopics(dir, log.Config{})
 	require.NoError(t, err)
 
 	cfg = &Config{
@@ -125,6 +198,16 @@ func testConsumePastBoundary(
 	if got != want {
 		t.Fatalf("got err: %v, want err: %v", got, want)
 	}
+
+	var info *errdetails.ErrorInfo
+	for _, d := range status.Convert(err).Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, "0", info.Metadata["lowest"])
+	require.Equal(t, "0", info.Metadata["highest"])
 }
 
 func testProduceConsumeStream(t *testing.T, client api.LogClient, config *Config) {
@@ -175,7 +258,451 @@ func testProduceConsumeStream(t *testing.T, client api.LogClient, config *Config
 			require.Equal(t, res.Record, &api.Record{
 				Value: record.Value,
 				Offset: uint64(i),
+				Timestamp: res.Record.Timestamp,
 			})
 		}
 	}
-}
\ No newline at end of file
+}
+func testProduceStreamCumulativeAck(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	for i := uint64(1); i <= 5; i++ {
+		err = stream.Send(&api.ProduceRequest{
+			Record:   &api.Record{Value: []byte("hello world")},
+			Sequence: i,
+		})
+		require.NoError(t, err)
+	}
+
+	// however the acks were grouped, the last one covers every record
+	var res *api.ProduceResponse
+	for res == nil || res.Sequence < 5 {
+		res, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint64(5), res.Sequence)
+	require.Equal(t, uint64(4), res.Offset)
+}
+
+func testConsumeStreamUntil(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+
+	until := uint64(1)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:      0,
+		UntilOffset: &until,
+	})
+	require.NoError(t, err)
+
+	for i := uint64(0); i <= until; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, i, res.Record.Offset)
+	}
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func testProduceBadRequest(t *testing.T, client api.LogClient, config *Config) {
+	_, err := client.Produce(context.Background(), &api.ProduceRequest{})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	br, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, "record", br.FieldViolations[0].Field)
+}
+
+func testProduceStreamResend(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	send := func(from, to uint64) *api.ProduceResponse {
+		stream, err := client.ProduceStream(ctx)
+		require.NoError(t, err)
+		for i := from; i <= to; i++ {
+			err = stream.Send(&api.ProduceRequest{
+				Record:     &api.Record{Value: []byte("hello world")},
+				Sequence:   i,
+				ProducerId: "producer-1",
+			})
+			require.NoError(t, err)
+		}
+		var res *api.ProduceResponse
+		for res == nil || res.Sequence < to {
+			res, err = stream.Recv()
+			require.NoError(t, err)
+		}
+		return res
+	}
+
+	res := send(1, 3)
+	require.Equal(t, uint64(2), res.Offset)
+
+	// reconnect and resend from 2; only 4 is new
+	res = send(2, 4)
+	require.Equal(t, uint64(4), res.Sequence)
+	require.Equal(t, uint64(3), res.Offset)
+
+	_, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 4})
+	require.Error(t, err)
+}
+
+func testTopics(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for _, topic := range []string{"a", "a", "b"} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(topic)},
+			Topic:  topic,
+		})
+		require.NoError(t, err)
+	}
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1, Topic: "a"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), consume.Record.Value)
+
+	consume, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0, Topic: "b"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), consume.Record.Value)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 1, Topic: "b"})
+	require.Error(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0, Topic: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("x")},
+		Topic:  "../escape",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testProduceBatch(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	var records []*api.Record
+	for i := 0; i < 100; i++ {
+		records = append(records, &api.Record{Value: []byte(fmt.Sprintf("record-%d", i))})
+	}
+	res, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+	require.Len(t, res.Offsets, len(records))
+
+	for i, off := range res.Offsets {
+		require.Equal(t, uint64(i), off)
+		consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: off})
+		require.NoError(t, err)
+		require.Equal(t, records[i].Value, consume.Record.Value)
+	}
+
+	_, err = client.ProduceBatch(ctx, &api.ProduceBatchRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testGetOffsets(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{Topic: "nope"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	res, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.LowestOffset)
+	require.Equal(t, uint64(2), res.HighestOffset)
+	require.Equal(t, uint64(3), res.NextOffset)
+}
+
+func testGetOffsetForTime(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for _, ts := range []int64{10, 20, 30} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world"), Timestamp: ts},
+		})
+		require.NoError(t, err)
+	}
+	res, err := client.GetOffsetForTime(ctx, &api.GetOffsetForTimeRequest{Timestamp: 15})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Offset)
+
+	// newer than everything: where the next record goes
+	res, err = client.GetOffsetForTime(ctx, &api.GetOffsetForTimeRequest{Timestamp: 31})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), res.Offset)
+}
+
+func testCloneTopic(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(value)},
+		})
+		require.NoError(t, err)
+	}
+	res, err := client.CloneTopic(ctx, &api.CloneTopicRequest{Clone: "staging"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Highest)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Topic: "staging", Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), consume.Record.Value)
+
+	_, err = client.CloneTopic(ctx, &api.CloneTopicRequest{Clone: "staging"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.CloneTopic(ctx, &api.CloneTopicRequest{Topic: "missing", Clone: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testSegmentsTruncate(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// enough to roll the default 1KiB segments a few times
+	for i := 0; i < 200; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	res, err := client.GetSegments(ctx, &api.GetSegmentsRequest{})
+	require.NoError(t, err)
+	require.Greater(t, len(res.Segments), 2)
+	require.Equal(t, uint64(0), res.Segments[0].BaseOffset)
+	require.Equal(t, uint64(200), res.Segments[len(res.Segments)-1].NextOffset)
+	require.NotZero(t, res.Segments[0].StoreBytes)
+
+	// only the first segment is entirely at or below its last offset
+	first := res.Segments[0]
+	truncated, err := client.TruncateTopic(ctx, &api.TruncateTopicRequest{Lowest: first.NextOffset - 1})
+	require.NoError(t, err)
+	require.Equal(t, first.NextOffset, truncated.Lowest)
+	after, err := client.GetSegments(ctx, &api.GetSegmentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, after.Segments, len(res.Segments)-1)
+
+	// a stream can't wait for records that were truncated away
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	_, err = client.GetSegments(ctx, &api.GetSegmentsRequest{Topic: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testGetServersUnimplemented(t *testing.T, client api.LogClient, config *Config) {
+	_, err := client.GetServers(context.Background(), &api.GetServersRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServerAuthorization(t *testing.T) {
+	// plaintext clients have no certificate, so they match only * subjects
+	authorizer, err := auth.Parse(strings.NewReader("*, default, produce\n"))
+	require.NoError(t, err)
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = authorizer
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	record := &api.Record{Value: []byte("hello world")}
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: record, Topic: "orders"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Consume(ctx, &api.ConsumeRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	// producing doesn't let a client throw away what's been written
+	_, err = client.TruncateTopic(ctx, &api.TruncateTopicRequest{Lowest: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+//	revocable allows everything until it's revoked
+type revocable struct {
+	revoked atomic.Bool
+}
+
+func (r *revocable) Authorize(subject, topic, action string) error {
+	if r.revoked.Load() {
+		return status.Error(codes.PermissionDenied, "revoked")
+	}
+	return nil
+}
+
+func TestServerResendAuthorization(t *testing.T) {
+	authorizer := &revocable{}
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = authorizer
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	produce := func() (*api.ProduceResponse, error) {
+		stream, err := client.ProduceStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&api.ProduceRequest{
+			Record:     &api.Record{Value: []byte("hello world")},
+			Sequence:   1,
+			ProducerId: "producer-1",
+		}))
+		return stream.Recv()
+	}
+	_, err := produce()
+	require.NoError(t, err)
+
+	// a resend is acked from memory, but not to a client that may no longer
+	// produce to the topic
+	authorizer.revoked.Store(true)
+	_, err = produce()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewTopics(dir, log.Config{})
+	require.NoError(t, err)
+
+	server, err := NewGRPCServer(&Config{CommitLog: clog})
+	require.NoError(t, err)
+	go server.Serve(l)
+
+	cc, err := grpc.NewClient(
+		l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer cc.Close()
+	client := api.NewLogClient(cc)
+
+	ctx := context.Background()
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	// a tail that would otherwise wait forever for offset 1
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, server.Shutdown(ctx))
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// the log was flushed and closed, so the record is there on reopening
+	reopened, err := log.NewTopics(dir, log.Config{})
+	require.NoError(t, err)
+	defer reopened.Close()
+	record, err := reopened.Read("", 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), record.Value)
+}
+
+func TestServerMetrics(t *testing.T) {
+	interval := 10 * time.Millisecond
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.Metrics.Interval = interval
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{
+		Records: []*api.Record{{Value: []byte("hello")}, {Value: []byte("world")}},
+	})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	// the rates times the interval add back up to what was done
+	require.Eventually(t, func() bool {
+		res, err := client.GetMetrics(ctx, &api.GetMetricsRequest{})
+		require.NoError(t, err)
+		var appended, consumed float64
+		for _, sample := range res.Samples {
+			appended += sample.AppendRate * interval.Seconds()
+			consumed += sample.ConsumeRate * interval.Seconds()
+		}
+		return math.Round(appended) == 2 && math.Round(consumed) == 1 &&
+			res.Samples[len(res.Samples)-1].DiskBytes > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerMetricsDisabled(t *testing.T) {
+	client, _, teardown := setupTest(t, nil)
+	defer teardown()
+	_, err := client.GetMetrics(context.Background(), &api.GetMetricsRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServerConsumeStreams(t *testing.T) {
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.ConsumeStreamsPerTopic = 1
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	res, err := client.GetConsumeStreams(ctx, &api.GetConsumeStreamsRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Streams, 1)
+	require.Equal(t, "default", res.Streams[0].Topic)
+	require.Equal(t, uint64(1), res.Streams[0].Offset)
+	require.NotEmpty(t, res.Streams[0].Peer)
+
+	// the topic is at its limit; others aren't
+	extra, err := client.ConsumeStream(ctx, &api.ConsumeRequest{})
+	require.NoError(t, err)
+	_, err = extra.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Topic: "other", Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+	_, err = client.ConsumeStream(ctx, &api.ConsumeRequest{Topic: "other"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		res, err := client.GetConsumeStreams(ctx, &api.GetConsumeStreamsRequest{Topic: "other"})
+		return err == nil && len(res.Streams) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// closing a stream frees its slot
+	cancel()
+	require.Eventually(t, func() bool {
+		res, err := client.GetConsumeStreams(context.Background(), &api.GetConsumeStreamsRequest{})
+		return err == nil && len(res.Streams) == 0
+	}, time.Second, 10*time.Millisecond)
+}
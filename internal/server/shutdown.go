@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	Server is the gRPC server for the log. It's used like a *grpc.Server, plus
+//		Shutdown to stop it without losing writes
+type Server struct {
+	*grpc.Server
+	srv *grpcServer
+}
+
+//	errShuttingDown ends streams when the server drains, so clients know to
+//		reconnect, to this server or another one
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down")
+
+//	Shutdown stops taking new connections and RPCs and waits for the ones in
+//		flight. Unary RPCs like Produce run to completion. Streams stop at
+//		their next wait for a request or record, and ProduceStream sends its
+//		pending ack before it stops. When ctx is done first, whatever is left
+//		is cut off. The CommitLog is closed after that if it's an io.Closer;
+//		closing flushes the stores, syncs the indexes and trims them to size
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.srv.drainOnce.Do(func() { close(s.srv.draining) })
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+		<-stopped
+		err = ctx.Err()
+	}
+	if c, ok := s.srv.CommitLog.(io.Closer); ok {
+		if cerr := c.Close(); cerr != nil {
+			return cerr
+		}
+	}
+	return err
+}
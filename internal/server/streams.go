@@ -0,0 +1,106 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/NathanClassen/hydralog/api/v1"
+	"github.com/NathanClassen/hydralog/internal/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//	streams keeps track of the ConsumeStreams open on the server, by topic,
+//		so they can be listed and a topic can be held to max of them at a
+//		time. A max of 0 is no limit
+type streams struct {
+	mu     sync.Mutex
+	max    int
+	nextID uint64
+	open   map[string]map[uint64]*consumeStream
+}
+
+//	consumeStream is one open stream. Only offset changes once it's open
+type consumeStream struct {
+	id      uint64
+	topic   string
+	subject string
+	peer    string
+	started time.Time
+	offset  atomic.Uint64
+	//	bytes of the record it's sending, if any
+	buffered atomic.Uint64
+}
+
+func newStreams(max int) *streams {
+	return &streams{
+		max:  max,
+		open: make(map[string]map[uint64]*consumeStream),
+	}
+}
+
+//	add registers a stream starting at offset, or fails with
+//		ResourceExhausted if its topic already has as many open as allowed
+func (s *streams) add(topic, subject, peer string, offset uint64) (*consumeStream, error) {
+	if topic == "" {
+		topic = log.DefaultTopic
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && len(s.open[topic]) >= s.max {
+		return nil, status.Errorf(
+			codes.ResourceExhausted,
+			"topic %q already has %d consume streams open", topic, s.max,
+		)
+	}
+	s.nextID++
+	c := &consumeStream{
+		id:      s.nextID,
+		topic:   topic,
+		subject: subject,
+		peer:    peer,
+		started: time.Now(),
+	}
+	c.offset.Store(offset)
+	if s.open[topic] == nil {
+		s.open[topic] = make(map[uint64]*consumeStream)
+	}
+	s.open[topic][c.id] = c
+	return c, nil
+}
+
+func (s *streams) remove(c *consumeStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.open[c.topic], c.id)
+	if len(s.open[c.topic]) == 0 {
+		delete(s.open, c.topic)
+	}
+}
+
+//	list describes the streams open on topic, oldest first
+func (s *streams) list(topic string) []*api.ConsumeStreamInfo {
+	if topic == "" {
+		topic = log.DefaultTopic
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]*api.ConsumeStreamInfo, 0, len(s.open[topic]))
+	for _, c := range s.open[topic] {
+		infos = append(infos, &api.ConsumeStreamInfo{
+			Id:            c.id,
+			Topic:         c.topic,
+			Subject:       c.subject,
+			Peer:          c.peer,
+			Offset:        c.offset.Load(),
+			Started:       c.started.UnixNano(),
+			BufferedBytes: c.buffered.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
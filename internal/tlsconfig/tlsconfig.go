@@ -0,0 +1,52 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+//	Config names the PEM files a server or client uses for TLS
+type Config struct {
+	//	the certificate to present and its key; both or neither
+	CertFile string
+	KeyFile  string
+	//	the CA that signed the other side's certificate. A server given one
+	//		requires and verifies client certificates
+	CAFile string
+	//	the name a client expects on the server's certificate
+	ServerAddress string
+	Server        bool
+}
+
+//	SetupTLSConfig builds a *tls.Config from the files in cfg, ready for
+//		credentials.NewTLS
+func SetupTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ca := x509.NewCertPool()
+		if !ca.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("tlsconfig: no certificates in %q", cfg.CAFile)
+		}
+		if cfg.Server {
+			tlsConfig.ClientCAs = ca
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.RootCAs = ca
+		}
+	}
+	tlsConfig.ServerName = cfg.ServerAddress
+	return tlsConfig, nil
+}
@@ -0,0 +1,119 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupTLSConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tlsconfig-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	file := func(name string) string { return path.Join(dir, name) }
+
+	server, err := SetupTLSConfig(Config{
+		CertFile: file("server.pem"),
+		KeyFile:  file("server-key.pem"),
+		CAFile:   file("ca.pem"),
+		Server:   true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, server.ClientAuth)
+
+	client, err := SetupTLSConfig(Config{
+		CertFile:      file("client.pem"),
+		KeyFile:       file("client-key.pem"),
+		CAFile:        file("ca.pem"),
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	require.NoError(t, handshake(server, client))
+
+	//	a client without a certificate is turned away
+	anonymous, err := SetupTLSConfig(Config{
+		CAFile:        file("ca.pem"),
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	require.Error(t, handshake(server, anonymous))
+
+	_, err = SetupTLSConfig(Config{CAFile: file("server-key.pem")})
+	require.Error(t, err)
+}
+
+//	handshake connects client to server over a pipe and returns the server's
+//		error, if it rejected the client
+func handshake(server, client *tls.Config) error {
+	s, c := net.Pipe()
+	defer s.Close()
+	defer c.Close()
+	go func() {
+		//	closing the pipe rather than the tls.Conn skips the close_notify,
+		//		which nobody would read
+		_ = tls.Client(c, client).Handshake()
+		c.Close()
+	}()
+	return tls.Server(s, server).Handshake()
+}
+
+//	writeCert writes a certificate and key for 127.0.0.1 to dir as
+//		<name>.pem and <name>-key.pem. Without a parent it's a self-signed CA
+func writeCert(
+	t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(
+		path.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		path.Join(dir, name+"-key.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600,
+	))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}